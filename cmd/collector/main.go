@@ -2,21 +2,64 @@ package main
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/c-j-p-nordquist/ekolod/internal/collector"
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/remotewrite"
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/events"
 	"github.com/c-j-p-nordquist/ekolod/pkg/health"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+	"github.com/c-j-p-nordquist/ekolod/pkg/secrets"
 )
 
 func main() {
-	// Load configuration from environment variables
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
+	// Load configuration from environment variables and the config file
+	configPath := os.Getenv("COLLECTOR_CONFIG")
+	if configPath == "" {
+		configPath = "configs/collector.yaml"
+	}
+	cfg, err := config.LoadCollectorConfig(configPath)
+	if err != nil {
+		logging.Fatal("Error loading collector config", logging.Err(err))
+	}
+	if err := logging.InitLogger(envOr("LOG_LEVEL", cfg.LogLevel), envOr("LOG_FORMAT", cfg.LogFormat)); err != nil {
+		logging.Fatal("Invalid logging configuration", logging.Err(err))
+	}
+	if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
+		cfg.Database.URL = dbURL
+		secrets.RegisterURL(dbURL)
+	}
+	if cfg.Database.Backend != config.BackendSQLite && cfg.Database.URL == "" {
+		logging.Fatal("DATABASE_URL environment variable is not set")
+	}
+	policy, err := store.ParsePolicy(cfg.Storage)
+	if err != nil {
+		logging.Fatal("Invalid storage configuration", logging.Err(err))
+	}
+
+	if len(os.Args) > 1 {
+		if os.Args[1] != "migrate" {
+			logging.Fatal("Unknown command, expected: migrate [up|status]", "command", os.Args[1])
+		}
+		runMigrate(cfg.Database, policy, os.Args[2:])
+		return
+	}
+
+	if err := collector.ValidateSLOs(cfg.SLOs); err != nil {
+		logging.Fatal("Invalid SLO configuration", logging.Err(err))
+	}
+	if err := collector.ValidateStatusPage(cfg.StatusPage); err != nil {
+		logging.Fatal("Invalid status page configuration", logging.Err(err))
+	}
+	if err := remotewrite.Validate(cfg.RemoteWrite); err != nil {
+		logging.Fatal("Invalid remote write configuration", logging.Err(err))
 	}
 
 	collectorPort := os.Getenv("COLLECTOR_PORT")
@@ -24,52 +67,161 @@ func main() {
 		collectorPort = "8081" // Default to 8081 if not set
 	}
 
-	// Retry connection to the database
-	var db *pgxpool.Pool
-	var err error
-	for i := 0; i < 30; i++ { // Try for 5 minutes
-		db, err = pgxpool.Connect(context.Background(), dbURL)
-		if err == nil {
-			// Try to create the table
-			_, err = db.Exec(context.Background(), `
-				CREATE TABLE IF NOT EXISTS metrics (
-					time TIMESTAMPTZ NOT NULL,
-					target TEXT NOT NULL,
-					check_type TEXT NOT NULL,
-					duration DOUBLE PRECISION NOT NULL,
-					success BOOLEAN NOT NULL,
-					message TEXT,
-					status_code INTEGER,
-					content_length BIGINT,
-					tls_version TEXT,
-					cert_expiry_days INTEGER
-				);
-				SELECT create_hypertable('metrics', 'time', if_not_exists => TRUE);
-			`)
-			if err == nil {
-				break
-			}
-		}
-		log.Printf("Failed to connect to database or create table. Retrying in 10 seconds...")
-		time.Sleep(10 * time.Second)
-	}
+	db, err := openStore(cfg.Database, policy)
 	if err != nil {
-		log.Fatalf("Unable to connect to database or create table after multiple attempts: %v", err)
+		logging.Fatal("Unable to connect to database after multiple attempts", logging.Err(err))
+	}
+
+	// Bring the schema up to date unless migrations are run separately
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		applied, err := db.Migrate(context.Background())
+		if err != nil {
+			logging.Fatal("Failed to migrate database", logging.Err(err))
+		}
+		for _, m := range applied {
+			logging.Info("Applied migration", "migration", fmt.Sprintf("%04d_%s", m.Version, m.Name))
+		}
 	}
-	defer db.Close()
 
-	log.Println("Successfully connected to the database and ensured table exists")
+	logging.Info("Successfully connected to the database", "backend", cfg.Database.Backend)
+
+	// Set up rollups, compression and retention
+	if err := db.ApplyStoragePolicies(context.Background()); err != nil {
+		logging.Warn("Failed to apply storage policies, serving queries from raw data only", logging.Err(err))
+	}
 
 	// Initialize health checker
 	healthChecker := health.New()
-	healthChecker.AddChecker(collector.NewDatabaseChecker(db))
+	healthChecker.AddChecker(collector.NewDatabaseChecker(db), health.CheckOptions{Readiness: true, Critical: true, Timeout: 5 * time.Second})
+	shutdownChecker := &health.ShutdownChecker{}
+	healthChecker.AddChecker(shutdownChecker, health.CheckOptions{Readiness: true, Critical: true, CacheTTL: -1})
+
+	// Initialize event broker for live result streaming
+	broker := events.NewBroker(1024)
+	publisher := collector.NewEventPublisher(broker)
+	// Every ingest publishes, so a blocked broker stalls ingestion
+	healthChecker.AddChecker(broker.Checker(), health.CheckOptions{Liveness: true, Critical: true})
+
+	// Resume incidents left open by a previous run
+	incidents, err := collector.NewIncidentTracker(context.Background(), db, broker, envDuration("INCIDENT_STALE_AFTER", time.Hour))
+	if err != nil {
+		logging.Fatal("Failed to load open incidents", logging.Err(err))
+	}
+
+	// Forward results to Prometheus if configured
+	var remote *remotewrite.Writer
+	if cfg.RemoteWrite.URL != "" {
+		remote = remotewrite.New(cfg.RemoteWrite)
+		logging.Info("Forwarding results to remote write endpoint", "url", cfg.RemoteWrite.URL)
+	}
 
 	// Set up HTTP routes
-	http.HandleFunc("/health", healthChecker.Handler())
-	http.HandleFunc("/metrics", collector.MetricsHandler(db))
-	http.HandleFunc("/timeseries", collector.TimeSeriesHandler(db))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", healthChecker.Handler())
+	mux.HandleFunc("/livez", healthChecker.LivenessHandler())
+	mux.HandleFunc("/readyz", healthChecker.ReadinessHandler())
+	mux.HandleFunc("/metrics", collector.MetricsHandler(db, publisher, incidents, remote))
+	mux.HandleFunc("/events", events.Handler(broker, events.DefaultKeepalive))
+	mux.HandleFunc("/timeseries", collector.TimeSeriesHandler(db))
+	mux.HandleFunc("/api/v1/uptime", collector.UptimeHandler(db))
+	mux.HandleFunc("/api/v1/slo", collector.SLOHandler(db, cfg.SLOs))
+	mux.HandleFunc("/api/v1/incidents", collector.IncidentsHandler(db))
+	mux.HandleFunc("/api/v1/incidents/", collector.IncidentHandler(db))
+	mux.HandleFunc("/badge/", collector.BadgeHandler(db, cfg.StatusPage))
+
+	// The status page is public, so only serve it once components are configured
+	if len(cfg.StatusPage.Components) > 0 {
+		statusBoard := collector.NewStatusBoard(db, cfg.StatusPage)
+		mux.HandleFunc("/status", statusBoard.HTMLHandler())
+		mux.HandleFunc("/api/v1/status", statusBoard.JSONHandler())
+	}
+
+	server := &http.Server{Addr: ":" + collectorPort, Handler: mux}
+	server.RegisterOnShutdown(broker.Close)
+
+	// Admin endpoints change how the process runs, so they get their own
+	// listener, bound to localhost unless ADMIN_ADDR says otherwise
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/admin/log-level", logging.LevelHandler())
+	adminServer := &http.Server{Addr: envOr("ADMIN_ADDR", "localhost:9081"), Handler: adminMux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go incidents.Run(ctx, time.Minute)
 
 	// Start the server
-	log.Printf("Starting Collector server on :%s", collectorPort)
-	log.Fatal(http.ListenAndServe(":"+collectorPort, nil))
+	go func() {
+		logging.Info("Starting Collector server", "addr", ":"+collectorPort)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal("Collector server failed", logging.Err(err))
+		}
+	}()
+	go func() {
+		logging.Info("Starting admin server", "addr", adminServer.Addr)
+		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal("Admin server failed", logging.Err(err))
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	// Fail readiness first so traffic is routed away before we stop serving
+	shutdownChecker.SetShuttingDown()
+	logging.Info("Shutting down, readiness set to unhealthy")
+	time.Sleep(envDuration("SHUTDOWN_DELAY", 5*time.Second))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), envDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	// Finish in-flight ingests before draining the database pool
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logging.Error("Shutting down HTTP server", logging.Err(err))
+	}
+	if err := adminServer.Shutdown(shutdownCtx); err != nil {
+		logging.Error("Shutting down admin server", logging.Err(err))
+	}
+	if remote != nil {
+		if err := remote.Close(shutdownCtx); err != nil {
+			logging.Error("Shutting down remote write", logging.Err(err))
+		}
+	}
+	db.Close()
+	logging.Info("Collector stopped")
+}
+
+// openStore retries until the database accepts connections
+func openStore(cfg config.DatabaseConfig, policy store.Policy) (store.Store, error) {
+	var err error
+	for i := 0; i < 30; i++ { // Try for 5 minutes
+		var db store.Store
+		db, err = store.Open(context.Background(), cfg, policy)
+		if err == nil {
+			return db, nil
+		}
+		logging.Warn("Failed to connect to database, retrying in 10 seconds", logging.Err(err))
+		time.Sleep(10 * time.Second)
+	}
+	return nil, err
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logging.Warn("Invalid duration, using default", "variable", name, "value", value, "default", fallback)
+		return fallback
+	}
+	return d
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+)
+
+// runMigrate implements `collector migrate [up|status]`.
+func runMigrate(cfg config.DatabaseConfig, policy store.Policy, args []string) {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	db, err := openStore(cfg, policy)
+	if err != nil {
+		logging.Fatal("Unable to connect to database after multiple attempts", logging.Err(err))
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		applied, err := db.Migrate(ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			logging.Fatal("Migration failed", logging.Err(err))
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	case "status":
+		statuses, err := db.MigrationStatus(ctx)
+		if err != nil {
+			logging.Fatal("Failed to read migration status", logging.Err(err))
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.UTC().Format("2006-01-02 15:04:05Z")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		w.Flush()
+	default:
+		logging.Fatal("Unknown migrate command, expected up or status", "command", command)
+	}
+}
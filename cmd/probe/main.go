@@ -1,13 +1,18 @@
 package main
 
 import (
-	"log"
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/c-j-p-nordquist/ekolod/internal/handlers"
 	"github.com/c-j-p-nordquist/ekolod/internal/probe"
+	"github.com/c-j-p-nordquist/ekolod/pkg/checkconverter"
 	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/events"
 	"github.com/c-j-p-nordquist/ekolod/pkg/health"
 	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
 	"github.com/c-j-p-nordquist/ekolod/pkg/metrics"
@@ -18,15 +23,16 @@ func main() {
 	// Load configuration
 	cfg, err := config.LoadConfig("configs/config.yaml")
 	if err != nil {
-		logging.Error(err)
-		log.Fatalf("Error loading config: %v", err)
+		logging.Fatal("Error loading config", logging.Err(err))
 	}
 
 	// Initialize logging
-	logging.InitLogger(cfg.LogLevel)
-
-	// Initialize metrics
-	metrics.InitMetrics()
+	if err := logging.InitLogger(envOr("LOG_LEVEL", cfg.LogLevel), envOr("LOG_FORMAT", cfg.LogFormat)); err != nil {
+		logging.Fatal("Invalid logging configuration", logging.Err(err))
+	}
+	if err := checkconverter.Validate(cfg); err != nil {
+		logging.Fatal("Invalid check configuration", logging.Err(err))
+	}
 
 	// Convert cfg.Targets to []*config.Target
 	targetPointers := make([]*config.Target, len(cfg.Targets))
@@ -36,20 +42,46 @@ func main() {
 	// Initialize health checker
 	healthChecker := health.New()
 	lastRunChecker := &probe.LastRunChecker{}
-	healthChecker.AddChecker(lastRunChecker)
-	healthChecker.AddChecker(&probe.CollectorReachableChecker{})
+	healthChecker.AddChecker(lastRunChecker, health.CheckOptions{Readiness: true, Critical: true})
+	// An unreachable collector degrades the probe but shouldn't restart it or take it out of rotation
+	healthChecker.AddChecker(&probe.CollectorReachableChecker{}, health.CheckOptions{Readiness: true})
+	shutdownChecker := &health.ShutdownChecker{}
+	healthChecker.AddChecker(shutdownChecker, health.CheckOptions{Readiness: true, Critical: true, CacheTTL: -1})
 
 	collectorURL := os.Getenv("COLLECTOR_URL")
 	if collectorURL == "" {
-		log.Fatal("COLLECTOR_URL environment variable is not set")
+		logging.Fatal("COLLECTOR_URL environment variable is not set")
 	}
 
 	if err := metricspusher.Init(collectorURL); err != nil {
-		log.Fatalf("Failed to initialize metric pusher: %v", err)
+		logging.Fatal("Failed to initialize metric pusher", logging.Err(err))
 	}
 
+	// Identify this probe to the collector, defaulting to the hostname
+	probeID := os.Getenv("PROBE_ID")
+	if probeID == "" {
+		probeID, _ = os.Hostname()
+	}
+	metricspusher.SetOrigin(probeID, os.Getenv("PROBE_LOCATION"))
+	logging.AddFields(logging.KeyProbeID, probeID)
+
+	// Initialize metrics
+	metrics.InitMetrics(probeID)
+
+	// Initialize event broker for live result streaming
+	broker := events.NewBroker(1024)
+
 	// Start HTTP probe
-	httpProbe := probe.NewHTTPProbe(targetPointers, lastRunChecker)
+	httpProbe := probe.NewHTTPProbe(targetPointers, lastRunChecker, probe.Options{
+		Events:     broker,
+		Scheduler:  cfg.Scheduler,
+		HTTPClient: cfg.HTTPClient,
+	})
+
+	// A stalled scheduler or event broker stops the probe from reporting, so restart it
+	healthChecker.AddChecker(httpProbe.SchedulerChecker(), health.CheckOptions{Liveness: true, Critical: true})
+	healthChecker.AddChecker(broker.Checker(), health.CheckOptions{Liveness: true, Critical: true})
+	healthChecker.Configure(cfg.Health)
 
 	// Run initial probe immediately
 	httpProbe.RunProbe()
@@ -62,7 +94,7 @@ func main() {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Last-Event-ID")
 			if r.Method == "OPTIONS" {
 				return
 			}
@@ -74,8 +106,12 @@ func main() {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", metrics.Handler())
 	mux.HandleFunc("/probe-metrics", handlers.ProbeMetricsHandler(httpProbe)) // JSON metrics
+	mux.HandleFunc("/events", events.Handler(broker, events.DefaultKeepalive))
 	mux.HandleFunc("/reload", handlers.ReloadHandler(httpProbe))
+	mux.HandleFunc("/probe", handlers.ModuleProbeHandler(httpProbe)) // blackbox exporter style
 	mux.HandleFunc("/health", healthChecker.Handler())
+	mux.HandleFunc("/livez", healthChecker.LivenessHandler())
+	mux.HandleFunc("/readyz", healthChecker.ReadinessHandler())
 
 	// Use CORS middleware
 	corsMux := corsHandler(mux)
@@ -85,6 +121,73 @@ func main() {
 	if probePort == "" {
 		probePort = "8080"
 	}
-	logging.Info("Starting Probe HTTP server on :" + probePort)
-	log.Fatal(http.ListenAndServe(":"+probePort, corsMux))
+	server := &http.Server{Addr: ":" + probePort, Handler: corsMux}
+	server.RegisterOnShutdown(broker.Close)
+
+	// Admin endpoints change how the process runs, so they get their own
+	// listener, bound to localhost unless ADMIN_ADDR says otherwise
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/admin/log-level", logging.LevelHandler())
+	adminServer := &http.Server{Addr: envOr("ADMIN_ADDR", "localhost:9080"), Handler: adminMux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		logging.Info("Starting Probe HTTP server", "addr", ":"+probePort)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal("Probe HTTP server failed", logging.Err(err))
+		}
+	}()
+	go func() {
+		logging.Info("Starting admin server", "addr", adminServer.Addr)
+		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Fatal("Admin server failed", logging.Err(err))
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	// Fail readiness first so traffic is routed away before we stop serving
+	shutdownChecker.SetShuttingDown()
+	logging.Info("Shutting down, readiness set to unhealthy")
+	time.Sleep(envDuration("SHUTDOWN_DELAY", 5*time.Second))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), envDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	if err := httpProbe.Shutdown(shutdownCtx); err != nil {
+		logging.Error("Shutting down probe", logging.Err(err))
+	}
+	if err := metricspusher.Flush(shutdownCtx); err != nil {
+		logging.Error("Flushing metric pusher", logging.Err(err))
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logging.Error("Shutting down HTTP server", logging.Err(err))
+	}
+	if err := adminServer.Shutdown(shutdownCtx); err != nil {
+		logging.Error("Shutting down admin server", logging.Err(err))
+	}
+	logging.Info("Probe stopped")
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logging.Warn("Invalid duration, using default", "variable", name, "value", value, "default", fallback)
+		return fallback
+	}
+	return d
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
 }
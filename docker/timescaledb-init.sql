@@ -1,19 +1,3 @@
--- Enable the TimescaleDB extension
+-- Enable the TimescaleDB extension. Tables are created by the collector's
+-- migrations (see internal/collector/migrations).
 CREATE EXTENSION IF NOT EXISTS timescaledb;
-
--- Create the metrics table if it doesn't exist
-CREATE TABLE IF NOT EXISTS metrics (
-    time TIMESTAMPTZ NOT NULL,
-    target TEXT NOT NULL,
-    check_type TEXT NOT NULL,
-    duration DOUBLE PRECISION NOT NULL,
-    success BOOLEAN NOT NULL,
-    message TEXT,
-    status_code INTEGER,
-    content_length BIGINT,
-    tls_version TEXT,
-    cert_expiry_days INTEGER
-);
-
--- Create the hypertable
-SELECT create_hypertable('metrics', 'time', if_not_exists => TRUE);
\ No newline at end of file
@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+const (
+	BadgeFlat       = "flat"
+	BadgeFlatSquare = "flat-square"
+	BadgePlastic    = "plastic"
+)
+
+var badgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"green":       "#97ca00",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+	"blue":        "#007ec6",
+	"grey":        "#555",
+	"lightgrey":   "#9f9f9f",
+}
+
+var hexColor = regexp.MustCompile(`^[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$`)
+
+// Badge is a two-part label/message badge in the style of shields.io.
+type Badge struct {
+	Label      string
+	Message    string
+	Color      string
+	LabelColor string
+	Style      string
+}
+
+// resolveColor accepts a named color or a hex value without the leading #.
+// Anything else yields fallback, which keeps user input out of the SVG.
+func resolveColor(color, fallback string) string {
+	if c, ok := badgeColors[color]; ok {
+		return c
+	}
+	if hexColor.MatchString(color) {
+		return "#" + color
+	}
+	return fallback
+}
+
+// textWidth approximates the rendered width of s in 11px Verdana.
+func textWidth(s string) int {
+	width := 0.0
+	for _, r := range s {
+		switch {
+		case strings.ContainsRune("iljI.,:;!|' ", r):
+			width += 3.5
+		case strings.ContainsRune("mwMW%", r):
+			width += 10
+		case r >= 'A' && r <= 'Z':
+			width += 7.5
+		default:
+			width += 6.5
+		}
+	}
+	return int(width + 0.5)
+}
+
+// SVG renders the badge.
+func (b Badge) SVG() string {
+	label := html.EscapeString(b.Label)
+	message := html.EscapeString(b.Message)
+	labelWidth := textWidth(b.Label) + 10
+	messageWidth := textWidth(b.Message) + 10
+	width := labelWidth + messageWidth
+	labelColor := resolveColor(b.LabelColor, badgeColors["grey"])
+	color := resolveColor(b.Color, badgeColors["lightgrey"])
+
+	radius, height, gradient := 3, 20, `<stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/>`
+	switch b.Style {
+	case BadgeFlatSquare:
+		radius, gradient = 0, ""
+	case BadgePlastic:
+		radius, height = 4, 18
+		gradient = `<stop offset="0" stop-color="#fff" stop-opacity=".7"/><stop offset=".1" stop-color="#aaa" stop-opacity=".1"/><stop offset=".9" stop-opacity=".3"/><stop offset="1" stop-opacity=".5"/>`
+	}
+	textY := height*10/2 + 40
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img" aria-label="%s: %s">`, width, height, label, message)
+	fmt.Fprintf(&svg, `<title>%s: %s</title>`, label, message)
+	if gradient != "" {
+		fmt.Fprintf(&svg, `<linearGradient id="s" x2="0" y2="100%%">%s</linearGradient>`, gradient)
+	}
+	fmt.Fprintf(&svg, `<clipPath id="r"><rect width="%d" height="%d" rx="%d" fill="#fff"/></clipPath>`, width, height, radius)
+	fmt.Fprintf(&svg, `<g clip-path="url(#r)"><rect width="%d" height="%d" fill="%s"/><rect x="%d" width="%d" height="%d" fill="%s"/>`,
+		labelWidth, height, labelColor, labelWidth, messageWidth, height, color)
+	if gradient != "" {
+		fmt.Fprintf(&svg, `<rect width="%d" height="%d" fill="url(#s)"/>`, width, height)
+	}
+	svg.WriteString(`</g>`)
+	svg.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="110">`)
+	for _, part := range []struct {
+		text  string
+		x     int
+		width int
+	}{
+		{label, labelWidth * 5, labelWidth - 10},
+		{message, labelWidth*10 + messageWidth*5, messageWidth - 10},
+	} {
+		fmt.Fprintf(&svg, `<text x="%d" y="%d" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="%d">%s</text>`,
+			part.x, textY+10, part.width*10, part.text)
+		fmt.Fprintf(&svg, `<text x="%d" y="%d" transform="scale(.1)" textLength="%d">%s</text>`,
+			part.x, textY, part.width*10, part.text)
+	}
+	svg.WriteString(`</g></svg>`)
+	return svg.String()
+}
@@ -0,0 +1,231 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+)
+
+const (
+	defaultUptimeBadgeWindow       = "30d"
+	defaultResponseTimeBadgeWindow = "24h"
+	// A target without results in this long is shown as unknown.
+	badgeFreshness = time.Hour
+)
+
+// BadgeHandler serves SVG badges under /badge/{target}/{status|uptime|response-time}.
+// The label, style, color and label_color query parameters customize the
+// badge, and window selects the period for uptime and response time.
+// Failures render as a badge too, since they end up embedded in pages.
+// Badges are public like the status page, so only the targets of its
+// components have them.
+func BadgeHandler(db store.Store, page config.StatusPageConfig) http.HandlerFunc {
+	public := make(map[string]bool)
+	for _, c := range page.Components {
+		for _, target := range c.Targets {
+			public[target] = true
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/badge/")
+		slash := strings.LastIndex(path, "/")
+		if slash <= 0 {
+			http.NotFound(w, r)
+			return
+		}
+		target, kind := path[:slash], path[slash+1:]
+		if !public[target] {
+			http.NotFound(w, r)
+			return
+		}
+
+		q := r.URL.Query()
+		var (
+			badge Badge
+			err   error
+		)
+		switch kind {
+		case "status":
+			badge, err = statusBadge(r.Context(), db, target)
+		case "uptime":
+			badge, err = uptimeBadge(r.Context(), db, target, q.Get("window"))
+		case "response-time":
+			badge, err = responseTimeBadge(r.Context(), db, target, q.Get("window"))
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logging.Error("Failed to build badge", "badge", kind, logging.KeyTarget, target, logging.Err(err))
+			badge = Badge{Label: kind, Message: "error", Color: "lightgrey"}
+		}
+
+		if label := q.Get("label"); label != "" {
+			badge.Label = label
+		}
+		if color := q.Get("color"); color != "" {
+			badge.Color = color
+		}
+		badge.LabelColor = q.Get("label_color")
+		badge.Style = q.Get("style")
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, badge.SVG())
+	}
+}
+
+// statusBadge reports whether the latest result of every check passed at
+// every probe.
+func statusBadge(ctx context.Context, db store.Store, target string) (Badge, error) {
+	now := time.Now()
+	samples, err := db.Samples(ctx, target, now.Add(-badgeFreshness), now.Add(time.Second))
+	if err != nil {
+		return Badge{}, err
+	}
+
+	badge := Badge{Label: "status", Message: "unknown", Color: "lightgrey"}
+	if len(samples) == 0 {
+		return badge, nil
+	}
+	latest := make(map[string]bool)
+	for _, s := range samples {
+		latest[s.Probe+"\x00"+s.Check] = s.Success
+	}
+	badge.Message, badge.Color = "up", "brightgreen"
+	for _, ok := range latest {
+		if !ok {
+			badge.Message, badge.Color = "down", "red"
+		}
+	}
+	return badge, nil
+}
+
+func uptimeBadge(ctx context.Context, db store.Store, target, expr string) (Badge, error) {
+	if expr == "" {
+		expr = defaultUptimeBadgeWindow
+	}
+	now := time.Now()
+	window, err := ParseWindow(expr, now)
+	if err != nil {
+		return Badge{Label: "uptime", Message: "invalid window", Color: "lightgrey"}, nil
+	}
+
+	// Label the badge with the period it covers
+	label := "uptime " + expr
+	start := window.Start
+	if retainedFrom := retainedStart(db, window, now); retainedFrom != nil {
+		start = *retainedFrom
+		label = "uptime " + formatDays(db.RawRetention())
+	}
+	samples, err := db.Changes(ctx, target, start, window.End)
+	if err != nil {
+		return Badge{}, err
+	}
+	report := ComputeUptime(target, samples[target], window, now)
+
+	badge := Badge{Label: label, Message: "no data", Color: "lightgrey"}
+	if report.AvailabilityPercent == nil {
+		return badge, nil
+	}
+	p := *report.AvailabilityPercent
+	badge.Message = formatPercent(p)
+	switch {
+	case p >= 99.9:
+		badge.Color = "brightgreen"
+	case p >= 99:
+		badge.Color = "green"
+	case p >= 95:
+		badge.Color = "yellow"
+	case p >= 90:
+		badge.Color = "orange"
+	default:
+		badge.Color = "red"
+	}
+	return badge, nil
+}
+
+func responseTimeBadge(ctx context.Context, db store.Store, target, expr string) (Badge, error) {
+	if expr == "" {
+		expr = defaultResponseTimeBadgeWindow
+	}
+	now := time.Now()
+	window, err := ParseWindow(expr, now)
+	if err != nil {
+		return Badge{Label: "response time", Message: "invalid window", Color: "lightgrey"}, nil
+	}
+
+	// One bucket spanning the window, weighted in case it is split in two
+	series, err := db.Buckets(ctx, store.SeriesQuery{
+		Target:     target,
+		Start:      window.Start,
+		End:        window.End,
+		Step:       window.Duration().Round(time.Hour) + time.Hour,
+		Aggregates: []string{"avg", "count"},
+	})
+	if err != nil {
+		return Badge{}, err
+	}
+	var sum, count float64
+	for _, point := range series.Points {
+		avg, _ := point["avg_duration"].(float64)
+		n, _ := point["count"].(float64)
+		sum += avg * n
+		count += n
+	}
+
+	badge := Badge{Label: "response time", Message: "no data", Color: "lightgrey"}
+	if count == 0 {
+		return badge, nil
+	}
+	avg := time.Duration(sum / count * float64(time.Second))
+	switch {
+	case avg < time.Second:
+		badge.Message = fmt.Sprintf("%dms", avg.Milliseconds())
+	default:
+		badge.Message = fmt.Sprintf("%.2fs", avg.Seconds())
+	}
+	switch {
+	case avg < 300*time.Millisecond:
+		badge.Color = "brightgreen"
+	case avg < time.Second:
+		badge.Color = "yellow"
+	default:
+		badge.Color = "red"
+	}
+	return badge, nil
+}
+
+// formatDays formats whole days as e.g. 30d.
+func formatDays(d time.Duration) string {
+	if d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
+
+// formatPercent keeps enough precision to tell nines apart. It truncates so
+// that 99.999% doesn't show as 100%.
+func formatPercent(p float64) string {
+	switch {
+	case p == 100:
+		return "100%"
+	case p >= 99:
+		return fmt.Sprintf("%.2f%%", math.Floor(p*100)/100)
+	default:
+		return fmt.Sprintf("%.1f%%", math.Floor(p*10)/10)
+	}
+}
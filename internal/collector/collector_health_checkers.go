@@ -2,26 +2,20 @@ package collector
 
 import (
 	"context"
-	"time"
 
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
 )
 
 type DatabaseChecker struct {
-	db *pgxpool.Pool
+	db store.Store
 }
 
-func NewDatabaseChecker(db *pgxpool.Pool) *DatabaseChecker {
+func NewDatabaseChecker(db store.Store) *DatabaseChecker {
 	return &DatabaseChecker{db: db}
 }
 
-func (c *DatabaseChecker) Check() bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var result int
-	err := c.db.QueryRow(ctx, "SELECT 1").Scan(&result)
-	return err == nil && result == 1
+func (c *DatabaseChecker) Check(ctx context.Context) error {
+	return c.db.Ping(ctx)
 }
 
 func (c *DatabaseChecker) Name() string {
@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/events"
+)
+
+type StateTransition struct {
+	Target  string    `json:"target"`
+	Check   string    `json:"check"`
+	Probe   string    `json:"probe,omitempty"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// stateKey identifies a check as seen by one probe. Probes in different
+// locations may disagree, so each keeps its own state.
+type stateKey struct {
+	probe  string
+	target string
+	check  string
+}
+
+// EventPublisher streams ingested results to SSE subscribers and emits a
+// state event whenever a check flips between up and down as seen by a probe.
+type EventPublisher struct {
+	broker *events.Broker
+	mu     sync.Mutex
+	last   map[stateKey]bool
+}
+
+func NewEventPublisher(broker *events.Broker) *EventPublisher {
+	return &EventPublisher{
+		broker: broker,
+		last:   make(map[stateKey]bool),
+	}
+}
+
+func (p *EventPublisher) Publish(probe, target, check string, success bool, message string, result interface{}) {
+	p.broker.Publish(events.TypeResult, target, check, result)
+
+	key := stateKey{probe: probe, target: target, check: check}
+	p.mu.Lock()
+	previous, seen := p.last[key]
+	p.last[key] = success
+	p.mu.Unlock()
+
+	if seen && previous == success {
+		return
+	}
+
+	from := "unknown"
+	if seen {
+		from = stateName(previous)
+	}
+	p.broker.Publish(events.TypeState, target, check, StateTransition{
+		Target:  target,
+		Check:   check,
+		Probe:   probe,
+		From:    from,
+		To:      stateName(success),
+		Message: message,
+		Time:    time.Now().UTC(),
+	})
+}
+
+func stateName(success bool) string {
+	if success {
+		return "up"
+	}
+	return "down"
+}
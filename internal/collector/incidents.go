@@ -0,0 +1,318 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+	"github.com/c-j-p-nordquist/ekolod/pkg/events"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+)
+
+// IncidentTracker derives incidents from ingested results. Like the uptime
+// report, a target is down from the first failing check until every check
+// passes again, across all probe locations. A failing check that stops
+// reporting, e.g. because it was removed from the probe configuration, no
+// longer holds its incident open after staleAfter.
+//
+// mu only guards the in-memory state. Incidents are saved without holding
+// it, so a slow database doesn't hold up results of other targets.
+type IncidentTracker struct {
+	db         store.Store
+	broker     *events.Broker
+	staleAfter time.Duration
+
+	mu      sync.Mutex
+	targets map[string]*targetIncidents
+}
+
+type targetIncidents struct {
+	// failing holds the check/location pairs whose last result failed,
+	// with the time of that result.
+	failing map[string]time.Time
+	open    *store.Incident
+	// unsaved holds the incidents changed since they were last saved,
+	// oldest first. One caller at a time saves them.
+	unsaved []*store.Incident
+	saving  bool
+}
+
+// NewIncidentTracker resumes the incidents left open by a previous run,
+// replaying the results stored since they started to find which checks are
+// still failing.
+func NewIncidentTracker(ctx context.Context, db store.Store, broker *events.Broker, staleAfter time.Duration) (*IncidentTracker, error) {
+	t := &IncidentTracker{
+		db:         db,
+		broker:     broker,
+		staleAfter: staleAfter,
+		targets:    make(map[string]*targetIncidents),
+	}
+	now := time.Now()
+
+	open, err := db.Incidents(ctx, store.IncidentFilter{Status: store.IncidentOpen})
+	if err != nil {
+		return nil, err
+	}
+	for i := range open {
+		incident := open[i]
+		samples, err := db.Samples(ctx, incident.Target, incident.StartedAt, now.Add(time.Second))
+		if err != nil {
+			return nil, err
+		}
+
+		// Stored results record the probe but not the location that
+		// live results are keyed by, so they are replayed per check
+		// only. The checks that failed count as failing since the
+		// incident started until they report again, so that the
+		// incident expires if they never do.
+		state := &targetIncidents{failing: make(map[string]time.Time), open: &incident}
+		for _, check := range incident.Checks {
+			state.failing[failingKey(check, "")] = incident.StartedAt
+		}
+		if len(state.failing) == 0 {
+			state.failing[failingKey("", "")] = incident.StartedAt
+		}
+		var recovered time.Time
+		for _, sample := range samples {
+			key := failingKey(sample.Check, "")
+			if sample.Success {
+				delete(state.failing, key)
+			} else {
+				state.failing[key] = sample.Time
+			}
+			if len(state.failing) == 0 {
+				recovered = sample.Time
+			}
+		}
+		if lastSeen, expired := t.expire(state, now); expired && len(state.failing) == 0 {
+			recovered = lastSeen
+		}
+
+		if len(state.failing) == 0 && !recovered.IsZero() {
+			recovered = recovered.UTC()
+			incident.ResolvedAt = &recovered
+			if err := db.UpdateIncident(ctx, &incident); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		t.targets[incident.Target] = state
+	}
+	return t, nil
+}
+
+// Observe updates the target's incident with a result reported from
+// location.
+func (t *IncidentTracker) Observe(ctx context.Context, result store.Result, location string) error {
+	t.mu.Lock()
+	state := t.targets[result.Target]
+	if state == nil {
+		state = &targetIncidents{failing: make(map[string]time.Time)}
+		t.targets[result.Target] = state
+	}
+	t.apply(state, result, location)
+	t.mu.Unlock()
+
+	return t.save(ctx, state)
+}
+
+// apply updates state with a result. The caller must hold mu.
+func (t *IncidentTracker) apply(state *targetIncidents, result store.Result, location string) {
+	if result.Success {
+		delete(state.failing, failingKey(result.Check, location))
+		delete(state.failing, failingKey(result.Check, ""))
+		if state.open == nil || len(state.failing) > 0 {
+			return
+		}
+
+		resolved := result.Time.UTC()
+		state.open.ResolvedAt = &resolved
+		state.changed(state.open)
+		state.open = nil
+		return
+	}
+
+	state.failing[failingKey(result.Check, location)] = result.Time
+
+	if state.open == nil {
+		state.open = &store.Incident{
+			Target:       result.Target,
+			Checks:       []string{},
+			StartedAt:    result.Time.UTC(),
+			FirstMessage: result.Message,
+			StatusCodes:  []int{},
+			Locations:    []string{},
+		}
+		addIncidentDetails(state.open, result, location)
+		state.changed(state.open)
+		return
+	}
+
+	if addIncidentDetails(state.open, result, location) {
+		state.changed(state.open)
+	}
+}
+
+// save writes the target's unsaved incidents to the database and publishes
+// those it opened or resolved. If another call is already saving them, save
+// returns at once and leaves the changes to that call. Incidents that fail
+// to save stay unsaved and are retried with the next result or sweep.
+func (t *IncidentTracker) save(ctx context.Context, state *targetIncidents) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state.saving {
+		return nil
+	}
+	state.saving = true
+	defer func() { state.saving = false }()
+
+	for len(state.unsaved) > 0 {
+		incident := state.unsaved[0]
+		state.unsaved = state.unsaved[1:]
+		snapshot := cloneIncident(incident)
+
+		t.mu.Unlock()
+		var err error
+		if snapshot.ID == 0 {
+			err = t.db.CreateIncident(ctx, &snapshot)
+		} else {
+			err = t.db.UpdateIncident(ctx, &snapshot)
+		}
+		t.mu.Lock()
+
+		if err != nil {
+			if !slices.Contains(state.unsaved, incident) {
+				state.unsaved = append([]*store.Incident{incident}, state.unsaved...)
+			}
+			return err
+		}
+		created := incident.ID == 0
+		incident.ID = snapshot.ID
+		if created || snapshot.ResolvedAt != nil {
+			t.publish(snapshot)
+		}
+	}
+	return nil
+}
+
+// Run resolves incidents held open only by stale checks every interval until
+// ctx is done.
+func (t *IncidentTracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := t.sweep(ctx, now); err != nil {
+				logging.Error("Failed to resolve stale incidents", logging.Err(err))
+			}
+		}
+	}
+}
+
+// sweep forgets failing checks that haven't reported within staleAfter and
+// resolves incidents that no longer have any, at the last failure seen.
+func (t *IncidentTracker) sweep(ctx context.Context, now time.Time) error {
+	t.mu.Lock()
+	states := make([]*targetIncidents, 0, len(t.targets))
+	for _, state := range t.targets {
+		states = append(states, state)
+		lastSeen, expired := t.expire(state, now)
+		if !expired || state.open == nil || len(state.failing) > 0 {
+			continue
+		}
+
+		resolved := lastSeen.UTC()
+		state.open.ResolvedAt = &resolved
+		logging.Info("Resolving incident of checks that stopped reporting", logging.KeyTarget, state.open.Target, "started_at", state.open.StartedAt)
+		state.changed(state.open)
+		state.open = nil
+	}
+	t.mu.Unlock()
+
+	// Saving every target also retries incidents that failed to save
+	var errs []error
+	for _, state := range states {
+		if err := t.save(ctx, state); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// expire drops the failing checks last seen more than staleAfter before now
+// and returns when the most recent of them was seen.
+func (t *IncidentTracker) expire(state *targetIncidents, now time.Time) (time.Time, bool) {
+	if t.staleAfter <= 0 {
+		return time.Time{}, false
+	}
+	var (
+		lastSeen time.Time
+		expired  bool
+	)
+	for key, seen := range state.failing {
+		if now.Sub(seen) <= t.staleAfter {
+			continue
+		}
+		delete(state.failing, key)
+		if seen.After(lastSeen) {
+			lastSeen = seen
+		}
+		expired = true
+	}
+	return lastSeen, expired
+}
+
+func (t *IncidentTracker) publish(incident store.Incident) {
+	if t.broker != nil {
+		t.broker.Publish(events.TypeIncident, incident.Target, "", incident)
+	}
+}
+
+// changed marks incident as unsaved.
+func (s *targetIncidents) changed(incident *store.Incident) {
+	if !slices.Contains(s.unsaved, incident) {
+		s.unsaved = append(s.unsaved, incident)
+	}
+}
+
+// cloneIncident copies an incident so it can be saved while the original
+// keeps changing.
+func cloneIncident(incident *store.Incident) store.Incident {
+	clone := *incident
+	clone.Checks = slices.Clone(incident.Checks)
+	clone.StatusCodes = slices.Clone(incident.StatusCodes)
+	clone.Locations = slices.Clone(incident.Locations)
+	return clone
+}
+
+// addIncidentDetails records the check, status code and location of a
+// failing result and reports whether anything was new.
+func addIncidentDetails(incident *store.Incident, result store.Result, location string) bool {
+	changed := false
+	if !slices.Contains(incident.Checks, result.Check) {
+		incident.Checks = append(incident.Checks, result.Check)
+		slices.Sort(incident.Checks)
+		changed = true
+	}
+	if location != "" && !slices.Contains(incident.Locations, location) {
+		incident.Locations = append(incident.Locations, location)
+		slices.Sort(incident.Locations)
+		changed = true
+	}
+	if result.StatusCode != 0 && !slices.Contains(incident.StatusCodes, result.StatusCode) {
+		incident.StatusCodes = append(incident.StatusCodes, result.StatusCode)
+		slices.Sort(incident.StatusCodes)
+		changed = true
+	}
+	return changed
+}
+
+func failingKey(check, location string) string {
+	return check + "\x00" + location
+}
@@ -0,0 +1,156 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+)
+
+const (
+	defaultIncidentLimit = 100
+	maxIncidentLimit     = 1000
+)
+
+// IncidentsHandler lists incidents overlapping a window, most recent first.
+func IncidentsHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := r.URL.Query()
+		window, err := windowFromQuery(q, defaultReportWindow)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter := store.IncidentFilter{
+			Target: q.Get("target"),
+			Status: q.Get("status"),
+			Since:  window.Start,
+			Until:  window.End,
+			Limit:  defaultIncidentLimit,
+		}
+
+		switch filter.Status {
+		case "", store.IncidentOpen, store.IncidentResolved:
+		default:
+			http.Error(w, "Invalid status, expected open or resolved", http.StatusBadRequest)
+			return
+		}
+		if ack := q.Get("acknowledged"); ack != "" {
+			acknowledged, err := strconv.ParseBool(ack)
+			if err != nil {
+				http.Error(w, "Invalid acknowledged", http.StatusBadRequest)
+				return
+			}
+			filter.Acknowledged = &acknowledged
+		}
+		if limit := q.Get("limit"); limit != "" {
+			filter.Limit, err = strconv.Atoi(limit)
+			if err != nil || filter.Limit <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+		if filter.Limit > maxIncidentLimit {
+			filter.Limit = maxIncidentLimit
+		}
+
+		incidents, err := db.Incidents(r.Context(), filter)
+		if err != nil {
+			http.Error(w, "Failed to fetch incidents", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(incidents)
+	}
+}
+
+// IncidentHandler serves a single incident under /api/v1/incidents/{id},
+// with POST {id}/ack to acknowledge it and POST {id}/notes to add a note.
+func IncidentHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/v1/incidents/")
+		idPart, action, _ := strings.Cut(path, "/")
+		id, err := strconv.ParseInt(idPart, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid incident id", http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+		case action == "ack" && r.Method == http.MethodPost:
+			var body struct {
+				By string `json:"by"`
+			}
+			if err := decodeOptionalBody(r, &body); err != nil {
+				http.Error(w, "Invalid request payload", http.StatusBadRequest)
+				return
+			}
+			if err := db.AcknowledgeIncident(r.Context(), id, body.By, time.Now().UTC()); err != nil {
+				http.Error(w, "Failed to acknowledge incident", http.StatusInternalServerError)
+				return
+			}
+		case action == "notes" && r.Method == http.MethodPost:
+			var note store.IncidentNote
+			if err := json.NewDecoder(r.Body).Decode(&note); err != nil || strings.TrimSpace(note.Text) == "" {
+				http.Error(w, "Invalid request payload, a note needs text", http.StatusBadRequest)
+				return
+			}
+			if _, err := db.Incident(r.Context(), id); err != nil {
+				writeIncidentError(w, err)
+				return
+			}
+			note = store.IncidentNote{IncidentID: id, Author: note.Author, Text: note.Text, CreatedAt: time.Now().UTC()}
+			if err := db.AddIncidentNote(r.Context(), &note); err != nil {
+				http.Error(w, "Failed to add note", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(note)
+			return
+		case action == "" || action == "ack" || action == "notes":
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		incident, err := db.Incident(r.Context(), id)
+		if err != nil {
+			writeIncidentError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(incident)
+	}
+}
+
+func writeIncidentError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Incident not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Failed to fetch incident", http.StatusInternalServerError)
+}
+
+// decodeOptionalBody decodes a JSON body if there is one.
+func decodeOptionalBody(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
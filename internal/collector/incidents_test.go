@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+)
+
+func openTestStore(t *testing.T) store.Store {
+	t.Helper()
+	ctx := context.Background()
+	db, err := store.OpenSQLite(ctx, filepath.Join(t.TempDir(), "ekolod.db"), store.Policy{})
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(db.Close)
+	if _, err := db.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db
+}
+
+func openIncidents(t *testing.T, db store.Store) []store.Incident {
+	t.Helper()
+	incidents, err := db.Incidents(context.Background(), store.IncidentFilter{Status: store.IncidentOpen})
+	if err != nil {
+		t.Fatalf("Incidents: %v", err)
+	}
+	return incidents
+}
+
+func TestConcurrentFailuresOpenOneIncident(t *testing.T) {
+	ctx := context.Background()
+	db := openTestStore(t)
+	tracker, err := NewIncidentTracker(ctx, db, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("NewIncidentTracker: %v", err)
+	}
+
+	now := time.Now()
+	var wg sync.WaitGroup
+	for i, location := range []string{"eu", "us", "ap", "eu", "us", "ap"} {
+		wg.Add(1)
+		go func(i int, location string) {
+			defer wg.Done()
+			result := store.Result{Time: now.Add(time.Duration(i) * time.Millisecond), Target: "api", Check: "/", StatusCode: 503}
+			if err := tracker.Observe(ctx, result, location); err != nil {
+				t.Errorf("Observe: %v", err)
+			}
+		}(i, location)
+	}
+	// Changes left to a concurrent save are saved by the time it returns
+	wg.Wait()
+
+	incidents := openIncidents(t, db)
+	if len(incidents) != 1 {
+		t.Fatalf("%d open incidents, want 1", len(incidents))
+	}
+	if got := incidents[0].Locations; len(got) != 3 {
+		t.Errorf("locations = %v, want all three", got)
+	}
+}
+
+func TestReopenedIncidentExpiresWithoutResults(t *testing.T) {
+	ctx := context.Background()
+	db := openTestStore(t)
+	started := time.Now().Add(-30 * time.Minute).UTC()
+	incident := &store.Incident{Target: "gone", Checks: []string{"/"}, StartedAt: started, StatusCodes: []int{}, Locations: []string{}}
+	if err := db.CreateIncident(ctx, incident); err != nil {
+		t.Fatalf("CreateIncident: %v", err)
+	}
+
+	// The target reports nothing after the restart
+	tracker, err := NewIncidentTracker(ctx, db, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("NewIncidentTracker: %v", err)
+	}
+	if err := tracker.sweep(ctx, time.Now()); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if len(openIncidents(t, db)) != 1 {
+		t.Fatal("incident resolved before its checks went stale")
+	}
+
+	if err := tracker.sweep(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	resolved, err := db.Incident(ctx, incident.ID)
+	if err != nil {
+		t.Fatalf("Incident: %v", err)
+	}
+	if resolved.ResolvedAt == nil || !resolved.ResolvedAt.Equal(started) {
+		t.Errorf("resolved at %v, want %v", resolved.ResolvedAt, started)
+	}
+}
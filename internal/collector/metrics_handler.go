@@ -5,10 +5,12 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/remotewrite"
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
 )
 
-func MetricsHandler(db *pgxpool.Pool) http.HandlerFunc {
+func MetricsHandler(db store.Store, publisher *EventPublisher, incidents *IncidentTracker, remote *remotewrite.Writer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -16,8 +18,13 @@ func MetricsHandler(db *pgxpool.Pool) http.HandlerFunc {
 		}
 
 		var payload struct {
-			Target string `json:"target"`
-			Check  string `json:"check"`
+			Target   string `json:"target"`
+			Check    string `json:"check"`
+			Probe    string `json:"probe"`
+			Location string `json:"location"`
+			// Time is when the probe finished the check. Results that
+			// were retried arrive later.
+			Time   time.Time `json:"time"`
 			Result struct {
 				Duration       float64 `json:"duration"`
 				Success        bool    `json:"success"`
@@ -26,6 +33,10 @@ func MetricsHandler(db *pgxpool.Pool) http.HandlerFunc {
 				ContentLength  int64   `json:"contentLength"`
 				TLSVersion     string  `json:"tlsVersion"`
 				CertExpiryDays int     `json:"certExpiryDays"`
+				// Assertions is passed through as reported by the probe.
+				Assertions json.RawMessage `json:"assertions,omitempty"`
+				Steps      json.RawMessage `json:"steps,omitempty"`
+				Redirects  json.RawMessage `json:"redirects,omitempty"`
 			} `json:"result"`
 		}
 
@@ -34,18 +45,53 @@ func MetricsHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		_, err := db.Exec(r.Context(),
-			`INSERT INTO metrics (time, target, check_type, duration, success, message, status_code, content_length, tls_version, cert_expiry_days)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
-			time.Now(), payload.Target, payload.Check,
-			payload.Result.Duration, payload.Result.Success, payload.Result.Message,
-			payload.Result.StatusCode, payload.Result.ContentLength,
-			payload.Result.TLSVersion, payload.Result.CertExpiryDays)
+		// Don't trust a probe clock that is ahead
+		at := time.Now()
+		if !payload.Time.IsZero() && payload.Time.Before(at) {
+			at = payload.Time
+		}
 
-		if err != nil {
+		result := store.Result{
+			Time:           at,
+			Target:         payload.Target,
+			Check:          payload.Check,
+			Probe:          payload.Probe,
+			Duration:       payload.Result.Duration,
+			Success:        payload.Result.Success,
+			Message:        payload.Result.Message,
+			StatusCode:     payload.Result.StatusCode,
+			ContentLength:  payload.Result.ContentLength,
+			TLSVersion:     payload.Result.TLSVersion,
+			CertExpiryDays: payload.Result.CertExpiryDays,
+			Assertions:     payload.Result.Assertions,
+			Steps:          payload.Result.Steps,
+			Redirects:      payload.Result.Redirects,
+		}
+		if err := db.Insert(r.Context(), result); err != nil {
+			logging.Error("Failed to insert result", logging.KeyTarget, payload.Target, logging.KeyCheck, payload.Check, logging.Err(err))
 			http.Error(w, "Failed to insert metrics", http.StatusInternalServerError)
 			return
 		}
+		logging.Debug("Ingested result", logging.KeyTarget, payload.Target, logging.KeyCheck, payload.Check, logging.KeyProbeID, payload.Probe,
+			logging.KeyStatusCode, payload.Result.StatusCode, logging.KeyDuration, payload.Result.Duration, "success", payload.Result.Success)
+
+		if incidents != nil {
+			location := payload.Location
+			if location == "" {
+				location = payload.Probe
+			}
+			if err := incidents.Observe(r.Context(), result, location); err != nil {
+				logging.Error("Failed to update incident", logging.KeyTarget, payload.Target, logging.Err(err))
+			}
+		}
+
+		if remote != nil {
+			remote.Append(result, payload.Probe)
+		}
+
+		if publisher != nil {
+			publisher.Publish(payload.Probe, payload.Target, payload.Check, payload.Result.Success, payload.Result.Message, payload.Result)
+		}
 
 		w.WriteHeader(http.StatusOK)
 	}
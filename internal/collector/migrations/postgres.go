@@ -0,0 +1,125 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// lockKey identifies the advisory lock that keeps concurrently starting
+// collectors from migrating at the same time.
+const lockKey = 0x656b6f6c6f64 // "ekolod"
+
+// UpPostgres applies every pending migration in order, each in its own transaction,
+// and returns the ones it applied.
+func UpPostgres(ctx context.Context, db *pgxpool.Pool) ([]Migration, error) {
+	migrations, err := Load(Postgres)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return nil, fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	if err := ensureVersionTable(ctx, conn.Conn()); err != nil {
+		return nil, err
+	}
+
+	// Read applied versions only once we hold the lock, so a collector that
+	// waited on another one sees its work.
+	applied, err := appliedVersions(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, exists := applied[m.Version]; exists {
+			continue
+		}
+
+		err := conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, m.SQL); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO schema_version (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// ListPostgres reports every known migration and when it was applied, if it was.
+func ListPostgres(ctx context.Context, db *pgxpool.Pool) ([]Status, error) {
+	migrations, err := Load(Postgres)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if err := ensureVersionTable(ctx, conn.Conn()); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses(migrations, applied), nil
+}
+
+func ensureVersionTable(ctx context.Context, conn *pgx.Conn) error {
+	_, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("creating schema_version table: %w", err)
+	}
+	return nil
+}
+
+func appliedVersions(ctx context.Context, conn *pgx.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
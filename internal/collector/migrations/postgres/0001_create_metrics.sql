@@ -0,0 +1,16 @@
+CREATE EXTENSION IF NOT EXISTS timescaledb;
+
+CREATE TABLE IF NOT EXISTS metrics (
+    time TIMESTAMPTZ NOT NULL,
+    target TEXT NOT NULL,
+    check_type TEXT NOT NULL,
+    duration DOUBLE PRECISION NOT NULL,
+    success BOOLEAN NOT NULL,
+    message TEXT,
+    status_code INTEGER,
+    content_length BIGINT,
+    tls_version TEXT,
+    cert_expiry_days INTEGER
+);
+
+SELECT create_hypertable('metrics', 'time', if_not_exists => TRUE);
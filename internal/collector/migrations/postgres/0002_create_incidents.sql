@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS incidents (
+    id BIGSERIAL PRIMARY KEY,
+    target TEXT NOT NULL,
+    checks TEXT[] NOT NULL DEFAULT '{}',
+    started_at TIMESTAMPTZ NOT NULL,
+    resolved_at TIMESTAMPTZ,
+    first_message TEXT NOT NULL DEFAULT '',
+    status_codes INTEGER[] NOT NULL DEFAULT '{}',
+    locations TEXT[] NOT NULL DEFAULT '{}',
+    acknowledged_at TIMESTAMPTZ,
+    acknowledged_by TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS incidents_target_started_idx ON incidents (target, started_at DESC);
+CREATE INDEX IF NOT EXISTS incidents_open_idx ON incidents (target) WHERE resolved_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS incident_notes (
+    id BIGSERIAL PRIMARY KEY,
+    incident_id BIGINT NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+    author TEXT NOT NULL DEFAULT '',
+    text TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS incident_notes_incident_idx ON incident_notes (incident_id);
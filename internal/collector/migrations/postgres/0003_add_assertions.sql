@@ -0,0 +1,2 @@
+-- Per-assertion results reported by the probe, as a JSON array.
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS assertions JSONB;
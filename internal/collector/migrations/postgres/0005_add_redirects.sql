@@ -0,0 +1,2 @@
+-- Redirects followed by checks, as a JSON array.
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS redirects JSONB;
@@ -0,0 +1,111 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// UpSQLite applies every pending migration in order, each in its own
+// transaction, and returns the ones it applied. The database must open
+// transactions with BEGIN IMMEDIATE so that each one holds the write lock
+// from the start and concurrent collectors serialize on it.
+func UpSQLite(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	migrations, err := Load(SQLite)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureSQLiteVersionTable(ctx, db); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		applied, err := applySQLite(ctx, db, m)
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if applied {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+func applySQLite(ctx context.Context, db *sql.DB, m Migration) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Check inside the transaction, another collector may have applied it
+	// while we waited for the lock.
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM schema_version WHERE version = ?`, m.Version).Scan(&count); err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, time.Now().UnixNano())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ListSQLite reports every known migration and when it was applied, if it
+// was.
+func ListSQLite(ctx context.Context, db *sql.DB) ([]Status, error) {
+	migrations, err := Load(SQLite)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureSQLiteVersionTable(ctx, db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(0, appliedAt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return statuses(migrations, applied), nil
+}
+
+func ensureSQLiteVersionTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("creating schema_version table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+-- Times are stored as Unix nanoseconds.
+CREATE TABLE IF NOT EXISTS metrics (
+    time INTEGER NOT NULL,
+    target TEXT NOT NULL,
+    check_type TEXT NOT NULL,
+    duration REAL NOT NULL,
+    success INTEGER NOT NULL,
+    message TEXT,
+    status_code INTEGER,
+    content_length INTEGER,
+    tls_version TEXT,
+    cert_expiry_days INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS metrics_time_idx ON metrics (time);
+CREATE INDEX IF NOT EXISTS metrics_target_time_idx ON metrics (target, time);
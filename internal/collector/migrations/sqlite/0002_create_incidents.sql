@@ -0,0 +1,25 @@
+-- Lists are stored as JSON arrays, times as Unix nanoseconds.
+CREATE TABLE IF NOT EXISTS incidents (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    target TEXT NOT NULL,
+    checks TEXT NOT NULL DEFAULT '[]',
+    started_at INTEGER NOT NULL,
+    resolved_at INTEGER,
+    first_message TEXT NOT NULL DEFAULT '',
+    status_codes TEXT NOT NULL DEFAULT '[]',
+    locations TEXT NOT NULL DEFAULT '[]',
+    acknowledged_at INTEGER,
+    acknowledged_by TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS incidents_target_started_idx ON incidents (target, started_at);
+
+CREATE TABLE IF NOT EXISTS incident_notes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+    author TEXT NOT NULL DEFAULT '',
+    text TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS incident_notes_incident_idx ON incident_notes (incident_id);
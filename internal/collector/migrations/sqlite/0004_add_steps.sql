@@ -0,0 +1,2 @@
+-- Per-step results of multi-step checks, as a JSON array.
+ALTER TABLE metrics ADD COLUMN steps TEXT;
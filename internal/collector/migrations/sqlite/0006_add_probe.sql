@@ -0,0 +1,2 @@
+-- The probe that reported each result. Older results have none.
+ALTER TABLE metrics ADD COLUMN probe TEXT;
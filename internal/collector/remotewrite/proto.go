@@ -0,0 +1,67 @@
+package remotewrite
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The remote_write protocol is a snappy-compressed prometheus.WriteRequest.
+// Only the fields we send are encoded:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value float64
+	// Timestamp is in milliseconds since the Unix epoch.
+	Timestamp int64
+}
+
+// TimeSeries holds samples of one series. Labels must be sorted by name and
+// samples by timestamp.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+func marshalWriteRequest(series []TimeSeries) []byte {
+	var b []byte
+	for _, ts := range series {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalTimeSeries(ts))
+	}
+	return b
+}
+
+func marshalTimeSeries(ts TimeSeries) []byte {
+	var b []byte
+	for _, l := range ts.Labels {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, l.Name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, l.Value)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, label)
+	}
+	for _, s := range ts.Samples {
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.Timestamp))
+
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sample)
+	}
+	return b
+}
@@ -0,0 +1,312 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+	"github.com/c-j-p-nordquist/ekolod/pkg/metrics"
+	"github.com/golang/snappy"
+)
+
+const (
+	defaultBatchSize     = 500
+	defaultFlushInterval = 5 * time.Second
+	defaultQueueSize     = 10000
+	defaultMaxRetries    = 5
+	defaultTimeout       = 30 * time.Second
+	minBackoff           = 100 * time.Millisecond
+	maxBackoff           = 10 * time.Second
+)
+
+// Writer forwards ingested results to a remote_write endpoint. Results are
+// queued without blocking ingest, sent in batches, and retried with backoff
+// on network errors, 5xx and 429 responses. When the queue is full, new
+// results are dropped.
+type Writer struct {
+	cfg    config.RemoteWriteConfig
+	client *http.Client
+	labels []Label
+
+	queue   chan []TimeSeries
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+	done    chan struct{}
+
+	// cancel aborts in-flight sends once Close gives up waiting.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var (
+	// reservedLabels are set on every series by the writer itself.
+	reservedLabels = []string{"__name__", "target", "check", "probe"}
+	labelName      = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Validate reports external labels that would overwrite the labels the
+// writer sets or that Prometheus reserves.
+func Validate(cfg config.RemoteWriteConfig) error {
+	for name := range cfg.ExternalLabels {
+		if slices.Contains(reservedLabels, name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("external label %q is reserved", name)
+		}
+		if !labelName.MatchString(name) {
+			return fmt.Errorf("invalid external label name %q", name)
+		}
+	}
+	return nil
+}
+
+func New(cfg config.RemoteWriteConfig) *Writer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	var labels []Label
+	for name, value := range cfg.ExternalLabels {
+		labels = append(labels, Label{Name: name, Value: value})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Writer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		labels: labels,
+		queue:  make(chan []TimeSeries, cfg.QueueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go w.run()
+	return w
+}
+
+// Append queues the samples derived from a result reported by probe.
+func (w *Writer) Append(result store.Result, probe string) {
+	series := w.seriesFor(result, probe)
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.queue <- series:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// seriesFor maps a result to one sample per metric.
+func (w *Writer) seriesFor(result store.Result, probe string) []TimeSeries {
+	timestamp := result.Time.UnixMilli()
+	success := 0.0
+	if result.Success {
+		success = 1
+	}
+
+	type metric struct {
+		name  string
+		value float64
+	}
+	// The same gauges as a probe's /metrics
+	gauges := []metric{
+		{metrics.CheckSuccessName, success},
+		{metrics.CheckLastDurationName, result.Duration},
+		{metrics.CheckStatusCodeName, float64(result.StatusCode)},
+		{metrics.CheckResponseSizeName, float64(result.ContentLength)},
+	}
+	if result.TLSVersion != "" {
+		gauges = append(gauges, metric{metrics.CertExpiryDaysName, float64(result.CertExpiryDays)})
+	}
+
+	series := make([]TimeSeries, 0, len(gauges))
+	for _, m := range gauges {
+		labels := append([]Label{
+			{Name: "__name__", Value: m.name},
+			{Name: "target", Value: result.Target},
+			{Name: "check", Value: result.Check},
+		}, w.labels...)
+		if probe != "" {
+			labels = append(labels, Label{Name: "probe", Value: probe})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+		series = append(series, TimeSeries{Labels: labels, Samples: []Sample{{Value: m.value, Timestamp: timestamp}}})
+	}
+	return series
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	var (
+		batch   []TimeSeries
+		samples int
+	)
+	flush := func() {
+		if samples == 0 {
+			return
+		}
+		w.send(merge(batch), samples)
+		batch, samples = nil, 0
+
+		if dropped := w.dropped.Swap(0); dropped > 0 {
+			logging.Warn("Remote write queue full, dropped results", "dropped", dropped)
+		}
+	}
+
+	for {
+		select {
+		case series, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, series...)
+			samples += len(series)
+			if samples >= w.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// merge combines samples of the same series, as remote_write expects each
+// series at most once per request.
+func merge(batch []TimeSeries) []TimeSeries {
+	index := make(map[string]int)
+	var merged []TimeSeries
+	for _, ts := range batch {
+		var key strings.Builder
+		for _, l := range ts.Labels {
+			key.WriteString(l.Name)
+			key.WriteByte(0)
+			key.WriteString(l.Value)
+			key.WriteByte(0)
+		}
+		if i, exists := index[key.String()]; exists {
+			merged[i].Samples = append(merged[i].Samples, ts.Samples...)
+			continue
+		}
+		index[key.String()] = len(merged)
+		merged = append(merged, TimeSeries{Labels: ts.Labels, Samples: append([]Sample(nil), ts.Samples...)})
+	}
+	for _, ts := range merged {
+		sort.SliceStable(ts.Samples, func(i, j int) bool { return ts.Samples[i].Timestamp < ts.Samples[j].Timestamp })
+	}
+	return merged
+}
+
+func (w *Writer) send(series []TimeSeries, samples int) {
+	body := snappy.Encode(nil, marshalWriteRequest(series))
+
+	backoff := minBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= w.cfg.MaxRetries {
+			logging.Error("Remote write failed, dropping samples", "samples", samples, logging.Err(err))
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-w.ctx.Done():
+			logging.Error("Remote write aborted, dropping samples", "samples", samples, logging.Err(err))
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// post sends one request and reports whether a failure is worth retrying.
+func (w *Writer) post(body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "ekolod-collector")
+	for name, secret := range w.cfg.Headers {
+		value, err := secret.Value()
+		if err != nil {
+			return false, fmt.Errorf("header %s: %w", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("server responded with status code %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Close stops accepting results and sends what is queued. In-flight
+// requests are abandoned when ctx is done.
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		w.cancel()
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-w.done
+		return fmt.Errorf("flushing remote write queue: %w", ctx.Err())
+	}
+}
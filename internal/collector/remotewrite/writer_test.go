@@ -0,0 +1,288 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// receiver is a remote_write endpoint that decodes every request and
+// answers with the status codes in responses, then 204.
+type receiver struct {
+	t         *testing.T
+	mu        sync.Mutex
+	requests  []*http.Request
+	series    []TimeSeries
+	responses []int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("reading body: %v", err)
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	if len(rc.responses) > 0 {
+		status := rc.responses[0]
+		rc.responses = rc.responses[1:]
+		w.WriteHeader(status)
+		return
+	}
+
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		rc.t.Errorf("decoding snappy: %v", err)
+		return
+	}
+	series, err := unmarshalWriteRequest(data)
+	if err != nil {
+		rc.t.Errorf("decoding write request: %v", err)
+		return
+	}
+	rc.series = append(rc.series, series...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newReceiver(t *testing.T, responses ...int) (*receiver, *httptest.Server) {
+	rc := &receiver{t: t, responses: responses}
+	server := httptest.NewServer(rc)
+	t.Cleanup(server.Close)
+	return rc, server
+}
+
+func closeWriter(t *testing.T, w *Writer) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestWriterEncoding(t *testing.T) {
+	rc, server := newReceiver(t)
+	t.Setenv("EKOLOD_TEST_ORG", "tenant-1")
+	w := New(config.RemoteWriteConfig{
+		URL:            server.URL,
+		Headers:        map[string]config.Secret{"X-Scope-OrgID": "env:EKOLOD_TEST_ORG"},
+		ExternalLabels: map[string]string{"source": "ekolod"},
+	})
+
+	at := time.UnixMilli(1700000000000)
+	w.Append(store.Result{Time: at, Target: "api", Check: "/health", Duration: 0.25, Success: true, StatusCode: 200, ContentLength: 1024}, "eu-1")
+	w.Append(store.Result{Time: at.Add(time.Minute), Target: "api", Check: "/health", Duration: 0.5, Success: false, StatusCode: 503, ContentLength: -1}, "eu-1")
+	closeWriter(t, w)
+
+	if len(rc.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(rc.requests))
+	}
+	req := rc.requests[0]
+	for name, want := range map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"X-Scope-OrgID":                     "tenant-1",
+	} {
+		if got := req.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	labels := func(name string) []Label {
+		return []Label{
+			{Name: "__name__", Value: name},
+			{Name: "check", Value: "/health"},
+			{Name: "probe", Value: "eu-1"},
+			{Name: "source", Value: "ekolod"},
+			{Name: "target", Value: "api"},
+		}
+	}
+	samples := func(first, second float64) []Sample {
+		return []Sample{{Value: first, Timestamp: 1700000000000}, {Value: second, Timestamp: 1700000060000}}
+	}
+	want := []TimeSeries{
+		{Labels: labels("ekolod_check_success"), Samples: samples(1, 0)},
+		{Labels: labels("ekolod_check_last_duration_seconds"), Samples: samples(0.25, 0.5)},
+		{Labels: labels("ekolod_check_status_code"), Samples: samples(200, 503)},
+		{Labels: labels("ekolod_check_response_size_bytes"), Samples: samples(1024, -1)},
+	}
+	if !reflect.DeepEqual(rc.series, want) {
+		t.Errorf("series = %+v\nwant %+v", rc.series, want)
+	}
+}
+
+func TestWriterRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []int
+		requests  int
+		delivered bool
+	}{
+		{"server error", []int{http.StatusServiceUnavailable, http.StatusInternalServerError}, 3, true},
+		{"rate limited", []int{http.StatusTooManyRequests}, 2, true},
+		{"bad request", []int{http.StatusBadRequest}, 1, false},
+		{"retries exhausted", []int{500, 500, 500}, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, server := newReceiver(t, tt.responses...)
+			w := New(config.RemoteWriteConfig{URL: server.URL, MaxRetries: 2})
+			w.Append(store.Result{Time: time.Now(), Target: "api", Check: "/", Success: true}, "")
+			closeWriter(t, w)
+
+			if len(rc.requests) != tt.requests {
+				t.Errorf("got %d requests, want %d", len(rc.requests), tt.requests)
+			}
+			if delivered := len(rc.series) > 0; delivered != tt.delivered {
+				t.Errorf("delivered = %v, want %v", delivered, tt.delivered)
+			}
+		})
+	}
+}
+
+func TestWriterDropsWhenQueueFull(t *testing.T) {
+	received := make(chan struct{}, 10)
+	release := make(chan struct{})
+	var requests int
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		received <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	w := New(config.RemoteWriteConfig{URL: server.URL, BatchSize: 1, QueueSize: 1})
+	result := store.Result{Time: time.Now(), Target: "api", Check: "/", Success: true}
+
+	// The first result is in flight, the second waits in the queue and the
+	// rest don't fit.
+	w.Append(result, "")
+	<-received
+	for i := 0; i < 3; i++ {
+		w.Append(result, "")
+	}
+	if dropped := w.dropped.Load(); dropped != 2 {
+		t.Errorf("dropped %d results, want 2", dropped)
+	}
+
+	close(release)
+	closeWriter(t, w)
+	if requests != 2 {
+		t.Errorf("got %d requests, want 2", requests)
+	}
+
+	// Results appended after Close are discarded
+	w.Append(result, "")
+}
+
+func TestValidate(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"source":   true,
+		"region_1": true,
+		"__name__": false,
+		"target":   false,
+		"check":    false,
+		"probe":    false,
+		"__meta":   false,
+		"1region":  false,
+		"re-gion":  false,
+	} {
+		err := Validate(config.RemoteWriteConfig{ExternalLabels: map[string]string{name: "x"}})
+		if (err == nil) != valid {
+			t.Errorf("Validate(%q) = %v, want valid %v", name, err, valid)
+		}
+	}
+}
+
+// unmarshalWriteRequest decodes what marshalWriteRequest encodes.
+func unmarshalWriteRequest(b []byte) ([]TimeSeries, error) {
+	var series []TimeSeries
+	err := fields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		var ts TimeSeries
+		err := fields(v, func(num protowire.Number, v []byte, _ uint64) error {
+			switch num {
+			case 1:
+				var l Label
+				err := fields(v, func(num protowire.Number, v []byte, _ uint64) error {
+					if num == 1 {
+						l.Name = string(v)
+					} else {
+						l.Value = string(v)
+					}
+					return nil
+				})
+				ts.Labels = append(ts.Labels, l)
+				return err
+			default:
+				var s Sample
+				err := fields(v, func(num protowire.Number, _ []byte, n uint64) error {
+					if num == 1 {
+						s.Value = math.Float64frombits(n)
+					} else {
+						s.Timestamp = int64(n)
+					}
+					return nil
+				})
+				ts.Samples = append(ts.Samples, s)
+				return err
+			}
+		})
+		series = append(series, ts)
+		return err
+	})
+	return series, err
+}
+
+// fields calls fn with each field of a message, passing length-delimited
+// values as bytes and numeric values as n.
+func fields(b []byte, fn func(num protowire.Number, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, length := protowire.ConsumeTag(b)
+		if length < 0 {
+			return protowire.ParseError(length)
+		}
+		b = b[length:]
+
+		var (
+			v []byte
+			n uint64
+		)
+		switch typ {
+		case protowire.BytesType:
+			v, length = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			n, length = protowire.ConsumeFixed64(b)
+		case protowire.VarintType:
+			n, length = protowire.ConsumeVarint(b)
+		default:
+			length = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if length < 0 {
+			return protowire.ParseError(length)
+		}
+		b = b[length:]
+		if err := fn(num, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package collector
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+)
+
+const (
+	statusPageDays     = 90
+	statusPageCacheTTL = 30 * time.Second
+	// A target without results in this long has no current status.
+	statusPageFreshness = time.Hour
+	pastIncidentLimit   = 20
+	day                 = 24 * time.Hour
+)
+
+const (
+	StatusOperational   = "operational"
+	StatusOutage        = "outage"
+	StatusPartialOutage = "partial_outage"
+	StatusMajorOutage   = "major_outage"
+	StatusNoData        = "no_data"
+)
+
+//go:embed status_page.html
+var statusPageHTML string
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"percent": func(p *float64) string {
+		if p == nil {
+			return "no data"
+		}
+		return fmt.Sprintf("%.2f%%", *p)
+	},
+	"barClass": func(p *float64) string {
+		switch {
+		case p == nil:
+			return "none"
+		case *p >= 99.9:
+			return "up"
+		case *p >= 99:
+			return "degraded"
+		default:
+			return "down"
+		}
+	},
+	"label": func(status string) string {
+		return map[string]string{
+			StatusOperational:   "Operational",
+			StatusOutage:        "Outage",
+			StatusPartialOutage: "Partial outage",
+			StatusMajorOutage:   "Major outage",
+			StatusNoData:        "No data",
+		}[status]
+	},
+	"duration": func(seconds float64) string {
+		return (time.Duration(seconds) * time.Second).String()
+	},
+}).Parse(statusPageHTML))
+
+// StatusPage is the public view of the configured components. It leaves out
+// targets that aren't part of a component and incident details such as
+// notes and failure messages.
+type StatusPage struct {
+	Title           string            `json:"title"`
+	Status          string            `json:"status"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	Components      []ComponentStatus `json:"components"`
+	ActiveIncidents []PublicIncident  `json:"active_incidents"`
+	PastIncidents   []PublicIncident  `json:"past_incidents"`
+}
+
+type ComponentStatus struct {
+	Name          string         `json:"name"`
+	Description   string         `json:"description,omitempty"`
+	Status        string         `json:"status"`
+	UptimePercent *float64       `json:"uptime_percent"`
+	Targets       []TargetStatus `json:"targets"`
+	Days          []DayStatus    `json:"days"`
+}
+
+type TargetStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type DayStatus struct {
+	Date          string   `json:"date"`
+	UptimePercent *float64 `json:"uptime_percent"`
+	Incidents     int      `json:"incidents"`
+}
+
+type PublicIncident struct {
+	ID              int64      `json:"id"`
+	Components      []string   `json:"components"`
+	StartedAt       time.Time  `json:"started_at"`
+	ResolvedAt      *time.Time `json:"resolved_at"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Acknowledged    bool       `json:"acknowledged"`
+}
+
+// StatusBoard builds the status page from stored results and incidents and
+// caches it briefly, since the page is public.
+type StatusBoard struct {
+	db  store.Store
+	cfg config.StatusPageConfig
+
+	mu       sync.Mutex
+	cached   *StatusPage
+	cachedAt time.Time
+}
+
+func NewStatusBoard(db store.Store, cfg config.StatusPageConfig) *StatusBoard {
+	if cfg.Title == "" {
+		cfg.Title = "Status"
+	}
+	return &StatusBoard{db: db, cfg: cfg}
+}
+
+// ValidateStatusPage checks the component configuration at startup.
+func ValidateStatusPage(cfg config.StatusPageConfig) error {
+	seen := make(map[string]bool)
+	for _, c := range cfg.Components {
+		if c.Name == "" {
+			return fmt.Errorf("status page component is missing a name")
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("status page component %q is defined twice", c.Name)
+		}
+		seen[c.Name] = true
+		if len(c.Targets) == 0 {
+			return fmt.Errorf("status page component %q has no targets", c.Name)
+		}
+	}
+	return nil
+}
+
+func (b *StatusBoard) JSONHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		page, err := b.Page(r.Context())
+		if err != nil {
+			logging.Error("Failed to build status page", logging.Err(err))
+			http.Error(w, "Failed to build status page", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+func (b *StatusBoard) HTMLHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		page, err := b.Page(r.Context())
+		if err != nil {
+			logging.Error("Failed to build status page", logging.Err(err))
+			http.Error(w, "Failed to build status page", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusPageTemplate.Execute(w, page); err != nil {
+			logging.Error("Failed to render status page", logging.Err(err))
+		}
+	}
+}
+
+// Page returns the current status page, rebuilding it when the cached one
+// is older than statusPageCacheTTL.
+func (b *StatusBoard) Page(ctx context.Context) (*StatusPage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now().UTC()
+	if b.cached != nil && now.Sub(b.cachedAt) < statusPageCacheTTL {
+		return b.cached, nil
+	}
+
+	page, err := b.build(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	b.cached, b.cachedAt = page, now
+	return page, nil
+}
+
+// dayCounts holds successful and total samples per day.
+type dayCounts struct {
+	successes float64
+	samples   float64
+}
+
+func (b *StatusBoard) build(ctx context.Context, now time.Time) (*StatusPage, error) {
+	start := now.Truncate(day).Add(-(statusPageDays - 1) * day)
+
+	// Daily success ratios per target and check, from rollups where available
+	series, err := b.db.Buckets(ctx, store.SeriesQuery{
+		Start:      start,
+		End:        now,
+		Step:       day,
+		Aggregates: []string{"success_ratio", "count"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	days := make(map[string][]dayCounts)
+	for _, point := range series.Points {
+		target, _ := point["target"].(string)
+		bucket, _ := point["time"].(time.Time)
+		ratio, _ := point["success_ratio"].(float64)
+		count, _ := point["count"].(float64)
+		i := int(bucket.Sub(start) / day)
+		if i < 0 || i >= statusPageDays {
+			continue
+		}
+		if days[target] == nil {
+			days[target] = make([]dayCounts, statusPageDays)
+		}
+		days[target][i].successes += ratio * count
+		days[target][i].samples += count
+	}
+
+	recent, err := b.db.Samples(ctx, "", now.Add(-statusPageFreshness), now.Add(time.Second))
+	if err != nil {
+		return nil, err
+	}
+	reporting := make(map[string]bool)
+	for _, s := range recent {
+		reporting[s.Target] = true
+	}
+
+	incidents, err := b.db.Incidents(ctx, store.IncidentFilter{Since: start, Until: now.Add(time.Second)})
+	if err != nil {
+		return nil, err
+	}
+	down := make(map[string]bool)
+	for _, incident := range incidents {
+		if incident.ResolvedAt == nil {
+			down[incident.Target] = true
+		}
+	}
+
+	page := &StatusPage{
+		Title:           b.cfg.Title,
+		UpdatedAt:       now,
+		Components:      []ComponentStatus{},
+		ActiveIncidents: []PublicIncident{},
+		PastIncidents:   []PublicIncident{},
+	}
+	componentsOf := make(map[string][]string)
+	for _, c := range b.cfg.Components {
+		component := ComponentStatus{Name: c.Name, Description: c.Description, Targets: []TargetStatus{}}
+		totals := make([]dayCounts, statusPageDays)
+		for _, target := range c.Targets {
+			componentsOf[target] = append(componentsOf[target], c.Name)
+
+			status := StatusNoData
+			switch {
+			case down[target]:
+				status = StatusOutage
+			case reporting[target]:
+				status = StatusOperational
+			}
+			component.Targets = append(component.Targets, TargetStatus{Name: target, Status: status})
+
+			for i, counts := range days[target] {
+				totals[i].successes += counts.successes
+				totals[i].samples += counts.samples
+			}
+		}
+		component.Status = combineStatuses(component.Targets)
+
+		var all dayCounts
+		for i, counts := range totals {
+			component.Days = append(component.Days, DayStatus{
+				Date:          start.Add(time.Duration(i) * day).Format("2006-01-02"),
+				UptimePercent: ratioPercent(counts),
+			})
+			all.successes += counts.successes
+			all.samples += counts.samples
+		}
+		component.UptimePercent = ratioPercent(all)
+		page.Components = append(page.Components, component)
+	}
+
+	componentIndex := make(map[string]int)
+	for i, c := range page.Components {
+		componentIndex[c.Name] = i
+	}
+	for _, incident := range incidents {
+		names := componentsOf[incident.Target]
+		if len(names) == 0 {
+			continue // not public
+		}
+
+		end := now
+		if incident.ResolvedAt != nil {
+			end = *incident.ResolvedAt
+		}
+		for _, name := range names {
+			c := &page.Components[componentIndex[name]]
+			for i := dayIndex(incident.StartedAt, start); i <= dayIndex(end, start) && i < statusPageDays; i++ {
+				if i >= 0 {
+					c.Days[i].Incidents++
+				}
+			}
+		}
+
+		public := PublicIncident{
+			ID:              incident.ID,
+			Components:      names,
+			StartedAt:       incident.StartedAt,
+			ResolvedAt:      incident.ResolvedAt,
+			DurationSeconds: end.Sub(incident.StartedAt).Round(time.Second).Seconds(),
+			Acknowledged:    incident.AcknowledgedAt != nil,
+		}
+		if incident.ResolvedAt == nil {
+			page.ActiveIncidents = append(page.ActiveIncidents, public)
+		} else if len(page.PastIncidents) < pastIncidentLimit {
+			page.PastIncidents = append(page.PastIncidents, public)
+		}
+	}
+
+	page.Status = overallStatus(page.Components)
+	return page, nil
+}
+
+// combineStatuses summarizes a component's targets.
+func combineStatuses(targets []TargetStatus) string {
+	var up, down int
+	for _, t := range targets {
+		switch t.Status {
+		case StatusOperational:
+			up++
+		case StatusOutage:
+			down++
+		}
+	}
+	switch {
+	case down == 0 && up == 0:
+		return StatusNoData
+	case down == 0:
+		return StatusOperational
+	case up == 0:
+		return StatusMajorOutage
+	default:
+		return StatusPartialOutage
+	}
+}
+
+func overallStatus(components []ComponentStatus) string {
+	var up, partial, down int
+	for _, c := range components {
+		switch c.Status {
+		case StatusOperational:
+			up++
+		case StatusPartialOutage:
+			partial++
+		case StatusMajorOutage:
+			down++
+		}
+	}
+	switch {
+	case up+partial+down == 0:
+		return StatusNoData
+	case partial+down == 0:
+		return StatusOperational
+	case up+partial == 0:
+		return StatusMajorOutage
+	default:
+		return StatusPartialOutage
+	}
+}
+
+func ratioPercent(counts dayCounts) *float64 {
+	if counts.samples == 0 {
+		return nil
+	}
+	p := counts.successes / counts.samples * 100
+	return &p
+}
+
+func dayIndex(t, start time.Time) int {
+	return int(t.Sub(start) / day)
+}
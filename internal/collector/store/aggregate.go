@@ -0,0 +1,169 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// aggregates maps the names clients may request to the SQL computing them
+// over a bucket of raw rows or of rollup rows, and the key used in the
+// response. Percentiles can't be derived from rollups.
+var aggregates = []struct {
+	name   string
+	key    string
+	sql    string
+	rollup string
+}{
+	{"avg", "avg_duration", "avg(duration)", "sum(duration_sum) / sum(samples)"},
+	{"min", "min_duration", "min(duration)", "min(duration_min)"},
+	{"max", "max_duration", "max(duration)", "max(duration_max)"},
+	{"p50", "p50_duration", "percentile_cont(0.5) WITHIN GROUP (ORDER BY duration)", ""},
+	{"p95", "p95_duration", "percentile_cont(0.95) WITHIN GROUP (ORDER BY duration)", ""},
+	{"p99", "p99_duration", "percentile_cont(0.99) WITHIN GROUP (ORDER BY duration)", ""},
+	{"success_ratio", "success_ratio", "avg(success::int)::double precision", "sum(successes)::double precision / sum(samples)"},
+	{"count", "count", "count(*)::double precision", "sum(samples)::double precision"},
+}
+
+// bucketOrigin matches TimescaleDB's time_bucket origin so that every
+// backend produces the same bucket boundaries.
+var bucketOrigin = time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC)
+
+// ParseAggregates validates a comma-separated list of aggregate names. An
+// empty list selects all of them.
+func ParseAggregates(param string) ([]string, error) {
+	if param == "" {
+		names := make([]string, len(aggregates))
+		for i, a := range aggregates {
+			names[i] = a.name
+		}
+		return names, nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		known := false
+		for _, a := range aggregates {
+			if a.name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown aggregate %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func isPercentile(name string) bool {
+	for _, a := range aggregates {
+		if a.name == name {
+			return a.rollup == ""
+		}
+	}
+	return false
+}
+
+func bucketStart(t time.Time, step time.Duration) time.Time {
+	offset := t.Sub(bucketOrigin)
+	buckets := offset / step
+	if offset < 0 && offset%step != 0 {
+		buckets--
+	}
+	return bucketOrigin.Add(buckets * step).UTC()
+}
+
+// aggregateResults computes buckets in Go for backends without time_bucket
+// and percentile_cont. Results must be sorted by time.
+func aggregateResults(results []Result, query SeriesQuery) []map[string]interface{} {
+	type key struct {
+		target string
+		check  string
+		start  time.Time
+	}
+	type bucket struct {
+		durations []float64
+		successes int
+	}
+
+	buckets := make(map[key]*bucket)
+	var keys []key
+	for _, r := range results {
+		k := key{target: r.Target, check: r.Check, start: bucketStart(r.Time, query.Step)}
+		b, exists := buckets[k]
+		if !exists {
+			b = &bucket{}
+			buckets[k] = b
+			keys = append(keys, k)
+		}
+		b.durations = append(b.durations, r.Duration)
+		if r.Success {
+			b.successes++
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].target != keys[j].target {
+			return keys[i].target < keys[j].target
+		}
+		if keys[i].check != keys[j].check {
+			return keys[i].check < keys[j].check
+		}
+		return keys[i].start.Before(keys[j].start)
+	})
+
+	points := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		b := buckets[k]
+		sorted := append([]float64(nil), b.durations...)
+		sort.Float64s(sorted)
+
+		point := map[string]interface{}{
+			"time":       k.start,
+			"target":     k.target,
+			"check_type": k.check,
+		}
+		for _, name := range query.Aggregates {
+			switch name {
+			case "avg":
+				var sum float64
+				for _, d := range sorted {
+					sum += d
+				}
+				point["avg_duration"] = sum / float64(len(sorted))
+			case "min":
+				point["min_duration"] = sorted[0]
+			case "max":
+				point["max_duration"] = sorted[len(sorted)-1]
+			case "p50":
+				point["p50_duration"] = percentile(sorted, 0.5)
+			case "p95":
+				point["p95_duration"] = percentile(sorted, 0.95)
+			case "p99":
+				point["p99_duration"] = percentile(sorted, 0.99)
+			case "success_ratio":
+				point["success_ratio"] = float64(b.successes) / float64(len(sorted))
+			case "count":
+				point["count"] = float64(len(sorted))
+			}
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// percentile interpolates like percentile_cont over sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	lower := math.Floor(pos)
+	i := int(lower)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-lower)*(sorted[i+1]-sorted[i])
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Incident is a period during which a target had at least one failing
+// check.
+type Incident struct {
+	ID             int64          `json:"id"`
+	Target         string         `json:"target"`
+	Checks         []string       `json:"checks"`
+	StartedAt      time.Time      `json:"started_at"`
+	ResolvedAt     *time.Time     `json:"resolved_at"`
+	FirstMessage   string         `json:"first_message"`
+	StatusCodes    []int          `json:"status_codes"`
+	Locations      []string       `json:"locations"`
+	AcknowledgedAt *time.Time     `json:"acknowledged_at"`
+	AcknowledgedBy string         `json:"acknowledged_by,omitempty"`
+	Notes          []IncidentNote `json:"notes"`
+}
+
+type IncidentNote struct {
+	ID         int64     `json:"id"`
+	IncidentID int64     `json:"incident_id"`
+	Author     string    `json:"author,omitempty"`
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const (
+	IncidentOpen     = "open"
+	IncidentResolved = "resolved"
+)
+
+// IncidentFilter selects incidents. Zero values match everything; Since and
+// Until select incidents that overlap [Since, Until).
+type IncidentFilter struct {
+	Target       string
+	Status       string
+	Acknowledged *bool
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
+
+// incidentQuery builds the query selecting columns of the incidents that
+// match filter. placeholder formats the nth argument and timeValue encodes
+// times for the backend.
+func incidentQuery(columns string, filter IncidentFilter, placeholder func(n int) string, timeValue func(time.Time) interface{}) (string, []interface{}) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, placeholder(len(args))))
+	}
+
+	if filter.Target != "" {
+		where("target = %s", filter.Target)
+	}
+	switch filter.Status {
+	case IncidentOpen:
+		conditions = append(conditions, "resolved_at IS NULL")
+	case IncidentResolved:
+		conditions = append(conditions, "resolved_at IS NOT NULL")
+	}
+	if filter.Acknowledged != nil {
+		if *filter.Acknowledged {
+			conditions = append(conditions, "acknowledged_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "acknowledged_at IS NULL")
+		}
+	}
+	if !filter.Since.IsZero() {
+		where("(resolved_at IS NULL OR resolved_at >= %s)", timeValue(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		where("started_at < %s", timeValue(filter.Until))
+	}
+
+	query := `SELECT ` + columns + ` FROM incidents`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += ` ORDER BY started_at DESC, id DESC`
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, filter.Limit)
+	}
+	return query, args
+}
+
+// nonNil keeps empty lists from being stored as NULL or encoded as null.
+func nonNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
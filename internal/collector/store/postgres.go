@@ -0,0 +1,359 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/migrations"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PostgresStore keeps results in a TimescaleDB hypertable with continuous
+// aggregates for coarser resolutions.
+type PostgresStore struct {
+	db          *pgxpool.Pool
+	resolutions *resolutions
+}
+
+func OpenPostgres(ctx context.Context, url string, policy Policy) (*PostgresStore, error) {
+	db, err := pgxpool.Connect(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStore{db: db, resolutions: newResolutions(policy)}, nil
+}
+
+func (s *PostgresStore) Insert(ctx context.Context, r Result) error {
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO metrics (time, target, check_type, probe, duration, success, message, status_code, content_length, tls_version, cert_expiry_days, assertions, steps, redirects)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		r.Time, r.Target, r.Check, r.Probe, r.Duration, r.Success, r.Message,
+		r.StatusCode, r.ContentLength, r.TLSVersion, r.CertExpiryDays, nullableJSON(r.Assertions), nullableJSON(r.Steps), nullableJSON(r.Redirects))
+	return err
+}
+
+func (s *PostgresStore) Raw(ctx context.Context, query SeriesQuery) ([]Result, error) {
+	// LIMIT NULL is no limit
+	var limit interface{}
+	if query.Limit > 0 {
+		limit = query.Limit
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT * FROM (
+			SELECT time, target, check_type, duration, success, assertions, steps, redirects
+			FROM metrics
+			WHERE time BETWEEN $1 AND $2
+			AND ($3 = '' OR target = $3)
+			AND ($4 = '' OR check_type = $4)
+			ORDER BY time DESC
+			LIMIT $5
+		) AS recent
+		ORDER BY time ASC
+	`, query.Start, query.End, query.Target, query.CheckType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var r Result
+		var assertions, steps, redirects *string
+		if err := rows.Scan(&r.Time, &r.Target, &r.Check, &r.Duration, &r.Success, &assertions, &steps, &redirects); err != nil {
+			return nil, err
+		}
+		if assertions != nil {
+			r.Assertions = json.RawMessage(*assertions)
+		}
+		if steps != nil {
+			r.Steps = json.RawMessage(*steps)
+		}
+		if redirects != nil {
+			r.Redirects = json.RawMessage(*redirects)
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// Buckets groups rows of the chosen source into step-sized time_bucket
+// buckets per target and check and computes the requested aggregates for
+// each. Aggregates the source can't provide are left out.
+func (s *PostgresStore) Buckets(ctx context.Context, query SeriesQuery) (*BucketSeries, error) {
+	src, step := s.resolutions.choose(query, time.Now())
+	series := &BucketSeries{Step: step, Source: src.table, Points: []map[string]interface{}{}}
+
+	var columns, keys []string
+	for _, name := range query.Aggregates {
+		for _, a := range aggregates {
+			if a.name != name {
+				continue
+			}
+			expr := a.sql
+			if !src.raw() {
+				expr = a.rollup
+			}
+			if expr != "" {
+				columns = append(columns, expr)
+				keys = append(keys, a.key)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return series, nil
+	}
+
+	sql := fmt.Sprintf(`
+		SELECT time_bucket($1::double precision * interval '1 second', %[1]s) AS step_bucket, target, check_type, %[2]s
+		FROM %[3]s
+		WHERE %[1]s >= $2 AND %[1]s < $3
+		AND ($4 = '' OR target = $4)
+		AND ($5 = '' OR check_type = $5)
+		GROUP BY step_bucket, target, check_type
+		ORDER BY target, check_type, step_bucket ASC
+	`, src.timeColumn, strings.Join(columns, ", "), src.table)
+
+	rows, err := s.db.Query(ctx, sql, step.Seconds(), query.Start, query.End, query.Target, query.CheckType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			bucket    time.Time
+			target    string
+			checkType string
+		)
+		values := make([]float64, len(keys))
+		dest := []interface{}{&bucket, &target, &checkType}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		point := map[string]interface{}{
+			"time":       bucket,
+			"target":     target,
+			"check_type": checkType,
+		}
+		for i, key := range keys {
+			point[key] = values[i]
+		}
+		series.Points = append(series.Points, point)
+	}
+	return series, rows.Err()
+}
+
+func (s *PostgresStore) Samples(ctx context.Context, target string, start, end time.Time) ([]Sample, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT time, target, COALESCE(probe, ''), check_type, success
+		FROM metrics
+		WHERE time >= $1 AND time < $2
+		AND ($3 = '' OR target = $3)
+		ORDER BY target, time ASC
+	`, start, end, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []Sample
+	for rows.Next() {
+		var sample Sample
+		if err := rows.Scan(&sample.Time, &sample.Target, &sample.Probe, &sample.Check, &sample.Success); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+func (s *PostgresStore) Changes(ctx context.Context, target string, start, end time.Time) (map[string]SampleChanges, error) {
+	rows, err := s.db.Query(ctx, fmt.Sprintf(changesQuery, "$1", "$2", "$3"), start, end, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make(map[string]SampleChanges)
+	for rows.Next() {
+		var (
+			sample          Sample
+			samples, failed int64
+		)
+		if err := rows.Scan(&sample.Time, &sample.Target, &sample.Probe, &sample.Check, &sample.Success, &samples, &failed); err != nil {
+			return nil, err
+		}
+		addChange(changes, sample, int(samples), int(failed))
+	}
+	return changes, rows.Err()
+}
+
+func (s *PostgresStore) RawRetention() time.Duration {
+	return s.resolutions.sources[0].retention
+}
+
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	var result int
+	if err := s.db.QueryRow(ctx, "SELECT 1").Scan(&result); err != nil {
+		return err
+	}
+	if result != 1 {
+		return fmt.Errorf("unexpected result from database: %d", result)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Migrate(ctx context.Context) ([]migrations.Migration, error) {
+	return migrations.UpPostgres(ctx, s.db)
+}
+
+func (s *PostgresStore) MigrationStatus(ctx context.Context) ([]migrations.Status, error) {
+	return migrations.ListPostgres(ctx, s.db)
+}
+
+func (s *PostgresStore) ApplyStoragePolicies(ctx context.Context) error {
+	return s.resolutions.apply(ctx, s.db)
+}
+
+func (s *PostgresStore) Close() {
+	s.db.Close()
+}
+
+const pgIncidentColumns = `id, target, checks, started_at, resolved_at, first_message, status_codes, locations, acknowledged_at, acknowledged_by`
+
+func (s *PostgresStore) CreateIncident(ctx context.Context, incident *Incident) error {
+	return s.db.QueryRow(ctx, `
+		INSERT INTO incidents (target, checks, started_at, resolved_at, first_message, status_codes, locations)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, incident.Target, nonNil(incident.Checks), incident.StartedAt, incident.ResolvedAt, incident.FirstMessage,
+		nonNil(incident.StatusCodes), nonNil(incident.Locations)).Scan(&incident.ID)
+}
+
+func (s *PostgresStore) UpdateIncident(ctx context.Context, incident *Incident) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE incidents SET checks = $2, status_codes = $3, locations = $4, resolved_at = $5
+		WHERE id = $1
+	`, incident.ID, nonNil(incident.Checks), nonNil(incident.StatusCodes), nonNil(incident.Locations), incident.ResolvedAt)
+	return err
+}
+
+func (s *PostgresStore) Incidents(ctx context.Context, filter IncidentFilter) ([]Incident, error) {
+	sql, args := incidentQuery(pgIncidentColumns, filter,
+		func(n int) string { return fmt.Sprintf("$%d", n) },
+		func(t time.Time) interface{} { return t })
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	incidents := []Incident{}
+	ids := []int64{}
+	for rows.Next() {
+		incident, err := scanPgIncident(rows)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, *incident)
+		ids = append(ids, incident.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	notes, err := s.notes(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range incidents {
+		if n, ok := notes[incidents[i].ID]; ok {
+			incidents[i].Notes = n
+		}
+	}
+	return incidents, nil
+}
+
+func (s *PostgresStore) Incident(ctx context.Context, id int64) (*Incident, error) {
+	incident, err := scanPgIncident(s.db.QueryRow(ctx, `SELECT `+pgIncidentColumns+` FROM incidents WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.notes(ctx, []int64{id})
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := notes[id]; ok {
+		incident.Notes = n
+	}
+	return incident, nil
+}
+
+func (s *PostgresStore) AcknowledgeIncident(ctx context.Context, id int64, by string, at time.Time) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE incidents SET acknowledged_at = $2, acknowledged_by = $3
+		WHERE id = $1 AND acknowledged_at IS NULL
+	`, id, at, by)
+	return err
+}
+
+func (s *PostgresStore) AddIncidentNote(ctx context.Context, note *IncidentNote) error {
+	return s.db.QueryRow(ctx, `
+		INSERT INTO incident_notes (incident_id, author, text, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, note.IncidentID, note.Author, note.Text, note.CreatedAt).Scan(&note.ID)
+}
+
+// notes returns the notes of the given incidents, oldest first.
+func (s *PostgresStore) notes(ctx context.Context, ids []int64) (map[int64][]IncidentNote, error) {
+	notes := make(map[int64][]IncidentNote)
+	if len(ids) == 0 {
+		return notes, nil
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, incident_id, author, text, created_at
+		FROM incident_notes
+		WHERE incident_id = ANY($1)
+		ORDER BY created_at ASC, id ASC
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var note IncidentNote
+		if err := rows.Scan(&note.ID, &note.IncidentID, &note.Author, &note.Text, &note.CreatedAt); err != nil {
+			return nil, err
+		}
+		notes[note.IncidentID] = append(notes[note.IncidentID], note)
+	}
+	return notes, rows.Err()
+}
+
+func scanPgIncident(row pgx.Row) (*Incident, error) {
+	var incident Incident
+	err := row.Scan(&incident.ID, &incident.Target, &incident.Checks, &incident.StartedAt, &incident.ResolvedAt,
+		&incident.FirstMessage, &incident.StatusCodes, &incident.Locations, &incident.AcknowledgedAt, &incident.AcknowledgedBy)
+	if err != nil {
+		return nil, err
+	}
+	incident.Notes = []IncidentNote{}
+	return &incident, nil
+}
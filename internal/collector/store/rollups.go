@@ -0,0 +1,251 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// source is a table the time series endpoint can read from: the raw
+// metrics hypertable or one of its continuous aggregates.
+type source struct {
+	table      string
+	timeColumn string
+	width      time.Duration
+	retention  time.Duration
+	refresh    rollupRefresh
+}
+
+type rollupRefresh struct {
+	startOffset    time.Duration
+	endOffset      time.Duration
+	scheduleEvery  time.Duration
+	bucketInterval string
+}
+
+func (s source) raw() bool {
+	return s.width == 0
+}
+
+// covers reports whether the source still holds data from start.
+func (s source) covers(start, now time.Time) bool {
+	return s.retention == 0 || !start.Before(now.Add(-s.retention))
+}
+
+// Policy is the parsed storage configuration. Zero durations keep data
+// forever and disable compression.
+type Policy struct {
+	CompressAfter   time.Duration
+	RawRetention    time.Duration
+	MinuteRetention time.Duration
+	HourRetention   time.Duration
+}
+
+func ParsePolicy(cfg config.StorageConfig) (Policy, error) {
+	parse := func(name, value string) (time.Duration, error) {
+		if value == "" {
+			return 0, nil
+		}
+		d, err := config.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid %s %q", name, value)
+		}
+		return d, nil
+	}
+
+	var (
+		policy Policy
+		err    error
+	)
+	if policy.RawRetention, err = parse("raw retention", cfg.Retention.Raw); err != nil {
+		return Policy{}, err
+	}
+	if policy.MinuteRetention, err = parse("1m retention", cfg.Retention.Minute); err != nil {
+		return Policy{}, err
+	}
+	if policy.HourRetention, err = parse("1h retention", cfg.Retention.Hour); err != nil {
+		return Policy{}, err
+	}
+	if policy.CompressAfter, err = parse("compress_after", cfg.CompressAfter); err != nil {
+		return Policy{}, err
+	}
+	return policy, nil
+}
+
+// resolutions manages the continuous aggregates, compression and retention
+// policies for the metrics hypertable and routes queries to the coarsest
+// table that can serve them.
+type resolutions struct {
+	sources       []source
+	compressAfter time.Duration
+	rollups       atomic.Bool
+}
+
+func newResolutions(policy Policy) *resolutions {
+	return &resolutions{
+		sources: []source{
+			{table: "metrics", timeColumn: "time", retention: policy.RawRetention},
+			{table: "metrics_1m", timeColumn: "bucket", width: time.Minute, retention: policy.MinuteRetention, refresh: rollupRefresh{
+				startOffset: time.Hour, endOffset: time.Minute, scheduleEvery: time.Minute, bucketInterval: "1 minute",
+			}},
+			{table: "metrics_1h", timeColumn: "bucket", width: time.Hour, retention: policy.HourRetention, refresh: rollupRefresh{
+				startOffset: 3 * time.Hour, endOffset: time.Hour, scheduleEvery: 30 * time.Minute, bucketInterval: "1 hour",
+			}},
+		},
+		compressAfter: policy.CompressAfter,
+	}
+}
+
+// apply creates the continuous aggregates and brings the compression and
+// retention policies in line with the configuration. Until it succeeds,
+// queries are served from raw data only.
+func (r *resolutions) apply(ctx context.Context, db *pgxpool.Pool) error {
+	for _, s := range r.sources[1:] {
+		statements := []string{
+			fmt.Sprintf(`
+				CREATE MATERIALIZED VIEW IF NOT EXISTS %s
+				WITH (timescaledb.continuous) AS
+				SELECT time_bucket(INTERVAL '%s', time) AS bucket, target, check_type,
+					count(*) AS samples,
+					sum(success::int) AS successes,
+					sum(duration) AS duration_sum,
+					min(duration) AS duration_min,
+					max(duration) AS duration_max
+				FROM metrics
+				GROUP BY bucket, target, check_type
+				WITH NO DATA`, s.table, s.refresh.bucketInterval),
+			// Include not yet materialized buckets in query results
+			fmt.Sprintf(`ALTER MATERIALIZED VIEW %s SET (timescaledb.materialized_only = false)`, s.table),
+			fmt.Sprintf(`SELECT add_continuous_aggregate_policy('%s',
+				start_offset => %s, end_offset => %s, schedule_interval => %s, if_not_exists => TRUE)`,
+				s.table, interval(s.refresh.startOffset), interval(s.refresh.endOffset), interval(s.refresh.scheduleEvery)),
+		}
+		for _, stmt := range statements {
+			if _, err := db.Exec(ctx, stmt); err != nil {
+				return fmt.Errorf("creating %s: %w", s.table, err)
+			}
+		}
+	}
+
+	if err := r.applyCompression(ctx, db); err != nil {
+		return err
+	}
+
+	for _, s := range r.sources {
+		if _, err := db.Exec(ctx, fmt.Sprintf(`SELECT remove_retention_policy('%s', if_exists => TRUE)`, s.table)); err != nil {
+			return fmt.Errorf("removing retention policy on %s: %w", s.table, err)
+		}
+		if s.retention == 0 {
+			continue
+		}
+		if _, err := db.Exec(ctx, fmt.Sprintf(`SELECT add_retention_policy('%s', %s)`, s.table, interval(s.retention))); err != nil {
+			return fmt.Errorf("adding retention policy on %s: %w", s.table, err)
+		}
+	}
+
+	r.rollups.Store(true)
+	return nil
+}
+
+func (r *resolutions) applyCompression(ctx context.Context, db *pgxpool.Pool) error {
+	if _, err := db.Exec(ctx, `SELECT remove_compression_policy('metrics', if_exists => TRUE)`); err != nil {
+		return fmt.Errorf("removing compression policy: %w", err)
+	}
+	if r.compressAfter == 0 {
+		return nil
+	}
+
+	// Compression settings can't be changed once chunks are compressed, so
+	// only enable it the first time.
+	var enabled bool
+	err := db.QueryRow(ctx, `
+		SELECT compression_enabled FROM timescaledb_information.hypertables
+		WHERE hypertable_name = 'metrics'
+	`).Scan(&enabled)
+	if err != nil {
+		return fmt.Errorf("reading compression settings: %w", err)
+	}
+	if !enabled {
+		_, err := db.Exec(ctx, `ALTER TABLE metrics SET (timescaledb.compress, timescaledb.compress_segmentby = 'target, check_type')`)
+		if err != nil {
+			return fmt.Errorf("enabling compression: %w", err)
+		}
+	}
+
+	if _, err := db.Exec(ctx, fmt.Sprintf(`SELECT add_compression_policy('metrics', %s)`, interval(r.compressAfter))); err != nil {
+		return fmt.Errorf("adding compression policy: %w", err)
+	}
+	return nil
+}
+
+// choose picks the coarsest source whose bucket width divides the step and
+// that still holds data from the start of the query. Percentiles can only
+// be computed from raw data. If nothing fits, it falls back to the finest
+// source that covers the range and widens the step to its bucket width.
+func (r *resolutions) choose(query SeriesQuery, now time.Time) (source, time.Duration) {
+	candidates := r.sources[:1]
+	if r.rollups.Load() {
+		candidates = r.sources
+	}
+
+	needsRaw := false
+	for _, name := range query.Aggregates {
+		if isPercentile(name) {
+			needsRaw = true
+		}
+	}
+
+	for i := len(candidates) - 1; i >= 0; i-- {
+		s := candidates[i]
+		if needsRaw && !s.raw() {
+			continue
+		}
+		if !s.covers(query.Start, now) {
+			continue
+		}
+		if s.raw() || (s.width <= query.Step && query.Step%s.width == 0) {
+			return s, query.Step
+		}
+	}
+
+	for _, s := range candidates {
+		if s.covers(query.Start, now) {
+			step := query.Step
+			if step < s.width {
+				step = s.width
+			}
+			return s, ceilStep(step, s.width)
+		}
+	}
+
+	// Nothing reaches back far enough; use whatever keeps data the longest.
+	longest := candidates[0]
+	for _, s := range candidates[1:] {
+		if longest.retention != 0 && (s.retention == 0 || s.retention > longest.retention) {
+			longest = s
+		}
+	}
+	step := query.Step
+	if step < longest.width {
+		step = longest.width
+	}
+	return longest, ceilStep(step, longest.width)
+}
+
+// ceilStep rounds step up to a multiple of width, so that widening it to a
+// bucket width never yields more buckets than requested.
+func ceilStep(step, width time.Duration) time.Duration {
+	if width == 0 || step%width == 0 {
+		return step
+	}
+	return (step/width + 1) * width
+}
+
+// interval formats a duration as a Postgres interval literal.
+func interval(d time.Duration) string {
+	return fmt.Sprintf("INTERVAL '%d seconds'", int64(d.Seconds()))
+}
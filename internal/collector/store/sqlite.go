@@ -0,0 +1,424 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/migrations"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+	_ "modernc.org/sqlite"
+)
+
+// pruneInterval is how often results past the raw retention are deleted.
+const pruneInterval = time.Hour
+
+// SQLiteStore keeps results in a single SQLite file. It needs no external
+// services, which suits small installs and local development. There are no
+// rollups; buckets are computed from raw results.
+type SQLiteStore struct {
+	db        *sql.DB
+	retention time.Duration
+
+	pruneOnce sync.Once
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func OpenSQLite(ctx context.Context, path string, policy Policy) (*SQLiteStore, error) {
+	// WAL lets queries run alongside ingest, and immediate transactions take
+	// the write lock up front so concurrent writers wait instead of failing.
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db, retention: policy.RawRetention, stop: make(chan struct{})}, nil
+}
+
+func (s *SQLiteStore) Insert(ctx context.Context, r Result) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO metrics (time, target, check_type, probe, duration, success, message, status_code, content_length, tls_version, cert_expiry_days, assertions, steps, redirects)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Time.UnixNano(), r.Target, r.Check, r.Probe, r.Duration, r.Success, r.Message,
+		r.StatusCode, r.ContentLength, r.TLSVersion, r.CertExpiryDays, nullableJSON(r.Assertions), nullableJSON(r.Steps), nullableJSON(r.Redirects))
+	return err
+}
+
+func (s *SQLiteStore) Raw(ctx context.Context, query SeriesQuery) ([]Result, error) {
+	return s.results(ctx, `time BETWEEN ? AND ?`, query, true)
+}
+
+func (s *SQLiteStore) Buckets(ctx context.Context, query SeriesQuery) (*BucketSeries, error) {
+	results, err := s.results(ctx, `time >= ? AND time < ?`, query, false)
+	if err != nil {
+		return nil, err
+	}
+	return &BucketSeries{Step: query.Step, Source: "metrics", Points: aggregateResults(results, query)}, nil
+}
+
+// results reads rows in a time range, with their assertions, steps and
+// redirects if detailed.
+func (s *SQLiteStore) results(ctx context.Context, timeRange string, query SeriesQuery, detailed bool) ([]Result, error) {
+	details := "NULL, NULL, NULL"
+	if detailed {
+		details = "assertions, steps, redirects"
+	}
+	// LIMIT -1 is no limit
+	limit := -1
+	if query.Limit > 0 {
+		limit = query.Limit
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT * FROM (
+			SELECT time, target, check_type, duration, success, `+details+`
+			FROM metrics
+			WHERE `+timeRange+`
+			AND (? = '' OR target = ?)
+			AND (? = '' OR check_type = ?)
+			ORDER BY time DESC
+			LIMIT ?
+		)
+		ORDER BY time ASC
+	`, query.Start.UnixNano(), query.End.UnixNano(), query.Target, query.Target, query.CheckType, query.CheckType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var (
+			r          Result
+			timestamp  int64
+			assertions *string
+			steps      *string
+			redirects  *string
+		)
+		if err := rows.Scan(&timestamp, &r.Target, &r.Check, &r.Duration, &r.Success, &assertions, &steps, &redirects); err != nil {
+			return nil, err
+		}
+		r.Time = time.Unix(0, timestamp)
+		if assertions != nil {
+			r.Assertions = json.RawMessage(*assertions)
+		}
+		if steps != nil {
+			r.Steps = json.RawMessage(*steps)
+		}
+		if redirects != nil {
+			r.Redirects = json.RawMessage(*redirects)
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+func (s *SQLiteStore) Samples(ctx context.Context, target string, start, end time.Time) ([]Sample, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT time, target, COALESCE(probe, ''), check_type, success
+		FROM metrics
+		WHERE time >= ? AND time < ?
+		AND (? = '' OR target = ?)
+		ORDER BY target, time ASC
+	`, start.UnixNano(), end.UnixNano(), target, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []Sample
+	for rows.Next() {
+		var (
+			sample    Sample
+			timestamp int64
+		)
+		if err := rows.Scan(&timestamp, &sample.Target, &sample.Probe, &sample.Check, &sample.Success); err != nil {
+			return nil, err
+		}
+		sample.Time = time.Unix(0, timestamp)
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+func (s *SQLiteStore) Changes(ctx context.Context, target string, start, end time.Time) (map[string]SampleChanges, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(changesQuery, "?", "?", "?"),
+		start.UnixNano(), end.UnixNano(), target, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make(map[string]SampleChanges)
+	for rows.Next() {
+		var (
+			sample          Sample
+			timestamp       int64
+			samples, failed int
+		)
+		if err := rows.Scan(&timestamp, &sample.Target, &sample.Probe, &sample.Check, &sample.Success, &samples, &failed); err != nil {
+			return nil, err
+		}
+		sample.Time = time.Unix(0, timestamp)
+		addChange(changes, sample, samples, failed)
+	}
+	return changes, rows.Err()
+}
+
+func (s *SQLiteStore) RawRetention() time.Duration {
+	return s.retention
+}
+
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLiteStore) Migrate(ctx context.Context) ([]migrations.Migration, error) {
+	return migrations.UpSQLite(ctx, s.db)
+}
+
+func (s *SQLiteStore) MigrationStatus(ctx context.Context) ([]migrations.Status, error) {
+	return migrations.ListSQLite(ctx, s.db)
+}
+
+// ApplyStoragePolicies prunes results past the raw retention and keeps
+// doing so periodically. Rollup and compression settings don't apply.
+func (s *SQLiteStore) ApplyStoragePolicies(ctx context.Context) error {
+	if s.retention == 0 {
+		return nil
+	}
+	if err := s.prune(ctx); err != nil {
+		return err
+	}
+
+	s.pruneOnce.Do(func() {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			ticker := time.NewTicker(pruneInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-s.stop:
+					return
+				case <-ticker.C:
+					if err := s.prune(context.Background()); err != nil {
+						logging.Error("Failed to prune old results", logging.Err(err))
+					}
+				}
+			}
+		}()
+	})
+	return nil
+}
+
+func (s *SQLiteStore) prune(ctx context.Context) error {
+	cutoff := time.Now().Add(-s.retention).UnixNano()
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM metrics WHERE time < ?`, cutoff); err != nil {
+		return fmt.Errorf("pruning results: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Close() {
+	close(s.stop)
+	s.wg.Wait()
+	s.db.Close()
+}
+
+const sqliteIncidentColumns = `id, target, checks, started_at, resolved_at, first_message, status_codes, locations, acknowledged_at, acknowledged_by`
+
+func (s *SQLiteStore) CreateIncident(ctx context.Context, incident *Incident) error {
+	checks, statusCodes, locations, err := encodeIncidentLists(incident)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO incidents (target, checks, started_at, resolved_at, first_message, status_codes, locations)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, incident.Target, checks, incident.StartedAt.UnixNano(), nullableTime(incident.ResolvedAt),
+		incident.FirstMessage, statusCodes, locations)
+	if err != nil {
+		return err
+	}
+	incident.ID, err = result.LastInsertId()
+	return err
+}
+
+func (s *SQLiteStore) UpdateIncident(ctx context.Context, incident *Incident) error {
+	checks, statusCodes, locations, err := encodeIncidentLists(incident)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE incidents SET checks = ?, status_codes = ?, locations = ?, resolved_at = ?
+		WHERE id = ?
+	`, checks, statusCodes, locations, nullableTime(incident.ResolvedAt), incident.ID)
+	return err
+}
+
+func (s *SQLiteStore) Incidents(ctx context.Context, filter IncidentFilter) ([]Incident, error) {
+	query, args := incidentQuery(sqliteIncidentColumns, filter,
+		func(int) string { return "?" },
+		func(t time.Time) interface{} { return t.UnixNano() })
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	incidents := []Incident{}
+	for rows.Next() {
+		incident, err := scanSQLiteIncident(rows)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, *incident)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range incidents {
+		if incidents[i].Notes, err = s.notes(ctx, incidents[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return incidents, nil
+}
+
+func (s *SQLiteStore) Incident(ctx context.Context, id int64) (*Incident, error) {
+	incident, err := scanSQLiteIncident(s.db.QueryRowContext(ctx, `SELECT `+sqliteIncidentColumns+` FROM incidents WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if incident.Notes, err = s.notes(ctx, id); err != nil {
+		return nil, err
+	}
+	return incident, nil
+}
+
+func (s *SQLiteStore) AcknowledgeIncident(ctx context.Context, id int64, by string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE incidents SET acknowledged_at = ?, acknowledged_by = ?
+		WHERE id = ? AND acknowledged_at IS NULL
+	`, at.UnixNano(), by, id)
+	return err
+}
+
+func (s *SQLiteStore) AddIncidentNote(ctx context.Context, note *IncidentNote) error {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO incident_notes (incident_id, author, text, created_at)
+		VALUES (?, ?, ?, ?)
+	`, note.IncidentID, note.Author, note.Text, note.CreatedAt.UnixNano())
+	if err != nil {
+		return err
+	}
+	note.ID, err = result.LastInsertId()
+	return err
+}
+
+// notes returns the notes of an incident, oldest first.
+func (s *SQLiteStore) notes(ctx context.Context, id int64) ([]IncidentNote, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, incident_id, author, text, created_at
+		FROM incident_notes
+		WHERE incident_id = ?
+		ORDER BY created_at ASC, id ASC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []IncidentNote{}
+	for rows.Next() {
+		var (
+			note      IncidentNote
+			createdAt int64
+		)
+		if err := rows.Scan(&note.ID, &note.IncidentID, &note.Author, &note.Text, &createdAt); err != nil {
+			return nil, err
+		}
+		note.CreatedAt = time.Unix(0, createdAt)
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+func scanSQLiteIncident(row interface{ Scan(...interface{}) error }) (*Incident, error) {
+	var (
+		incident                       Incident
+		startedAt                      int64
+		resolvedAt, acknowledgedAt     sql.NullInt64
+		checks, statusCodes, locations string
+	)
+	err := row.Scan(&incident.ID, &incident.Target, &checks, &startedAt, &resolvedAt,
+		&incident.FirstMessage, &statusCodes, &locations, &acknowledgedAt, &incident.AcknowledgedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	incident.StartedAt = time.Unix(0, startedAt)
+	incident.ResolvedAt = timeFromNullable(resolvedAt)
+	incident.AcknowledgedAt = timeFromNullable(acknowledgedAt)
+	lists := []struct {
+		column string
+		dest   interface{}
+	}{
+		{checks, &incident.Checks},
+		{statusCodes, &incident.StatusCodes},
+		{locations, &incident.Locations},
+	}
+	for _, list := range lists {
+		if err := json.Unmarshal([]byte(list.column), list.dest); err != nil {
+			return nil, fmt.Errorf("decoding incident %d: %w", incident.ID, err)
+		}
+	}
+	incident.Notes = []IncidentNote{}
+	return &incident, nil
+}
+
+func encodeIncidentLists(incident *Incident) (checks, statusCodes, locations string, err error) {
+	var data []byte
+	if data, err = json.Marshal(nonNil(incident.Checks)); err != nil {
+		return
+	}
+	checks = string(data)
+	if data, err = json.Marshal(nonNil(incident.StatusCodes)); err != nil {
+		return
+	}
+	statusCodes = string(data)
+	if data, err = json.Marshal(nonNil(incident.Locations)); err != nil {
+		return
+	}
+	locations = string(data)
+	return
+}
+
+func nullableTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+func timeFromNullable(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := time.Unix(0, n.Int64)
+	return &t
+}
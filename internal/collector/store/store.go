@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/migrations"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+)
+
+const defaultSQLitePath = "ekolod.db"
+
+// Store persists probe results and answers the collector's queries.
+type Store interface {
+	Insert(ctx context.Context, result Result) error
+	// Raw returns the results in the query range, oldest first. With a
+	// Limit, only that many of the most recent results are returned.
+	Raw(ctx context.Context, query SeriesQuery) ([]Result, error)
+	// Buckets aggregates results into step-sized buckets per target and
+	// check. The store may widen the step or read from a rollup.
+	Buckets(ctx context.Context, query SeriesQuery) (*BucketSeries, error)
+	// Samples returns the success samples in [start, end), sorted by target
+	// and time. An empty target matches all targets.
+	Samples(ctx context.Context, target string, start, end time.Time) ([]Sample, error)
+	// Changes summarizes the success samples in [start, end) per target,
+	// keeping only those that change the outcome of a check as seen by a
+	// probe. An empty target matches all targets.
+	Changes(ctx context.Context, target string, start, end time.Time) (map[string]SampleChanges, error)
+	// RawRetention is how long results are kept, or 0 if they are kept
+	// forever.
+	RawRetention() time.Duration
+
+	// CreateIncident stores a new incident and sets its ID.
+	CreateIncident(ctx context.Context, incident *Incident) error
+	// UpdateIncident saves the checks, status codes, locations and
+	// resolution time of an existing incident.
+	UpdateIncident(ctx context.Context, incident *Incident) error
+	// Incidents returns the matching incidents with their notes, most
+	// recent first.
+	Incidents(ctx context.Context, filter IncidentFilter) ([]Incident, error)
+	// Incident returns one incident with its notes, or ErrNotFound.
+	Incident(ctx context.Context, id int64) (*Incident, error)
+	// AcknowledgeIncident records who acknowledged an incident. Later
+	// acknowledgements don't overwrite the first.
+	AcknowledgeIncident(ctx context.Context, id int64, by string, at time.Time) error
+	AddIncidentNote(ctx context.Context, note *IncidentNote) error
+
+	Ping(ctx context.Context) error
+	Migrate(ctx context.Context) ([]migrations.Migration, error)
+	MigrationStatus(ctx context.Context) ([]migrations.Status, error)
+	// ApplyStoragePolicies brings rollups, compression and retention in
+	// line with the storage configuration.
+	ApplyStoragePolicies(ctx context.Context) error
+	Close()
+}
+
+type Result struct {
+	Time   time.Time
+	Target string
+	Check  string
+	// Probe identifies the probe that reported the result.
+	Probe          string
+	Duration       float64
+	Success        bool
+	Message        string
+	StatusCode     int
+	ContentLength  int64
+	TLSVersion     string
+	CertExpiryDays int
+	// Assertions is the probe's per-assertion report, kept as JSON.
+	Assertions json.RawMessage
+	// Steps is the per-step report of a multi-step check, kept as JSON.
+	Steps json.RawMessage
+	// Redirects are the redirects the check followed, kept as JSON.
+	Redirects json.RawMessage
+}
+
+type Sample struct {
+	Time    time.Time
+	Target  string
+	Probe   string
+	Check   string
+	Success bool
+}
+
+// SampleChanges is what uptime is computed from, so that it doesn't take
+// every sample of a target.
+type SampleChanges struct {
+	Samples       int
+	FailedSamples int
+	// Changes holds the first sample of each probe and check and every
+	// sample whose success differs from the one before it, sorted by time.
+	Changes []Sample
+}
+
+type SeriesQuery struct {
+	Target     string
+	CheckType  string
+	Start      time.Time
+	End        time.Time
+	Step       time.Duration
+	Aggregates []string
+	// Limit caps the number of raw results. 0 means no limit.
+	Limit int
+}
+
+type BucketSeries struct {
+	Step   time.Duration
+	Source string
+	Points []map[string]interface{}
+}
+
+// Open connects to the backend selected in cfg.
+func Open(ctx context.Context, cfg config.DatabaseConfig, policy Policy) (Store, error) {
+	switch cfg.Backend {
+	case "", config.BackendPostgres:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("no Postgres connection URL configured")
+		}
+		s, err := OpenPostgres(ctx, cfg.URL, policy)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case config.BackendSQLite:
+		path := cfg.Path
+		if path == "" {
+			path = defaultSQLitePath
+		}
+		s, err := OpenSQLite(ctx, path, policy)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown database backend %q", cfg.Backend)
+	}
+}
+
+// changesQuery selects the samples that change the success of a check as
+// seen by a probe, with the sample counts of their target. Results stored
+// before probes were recorded count as one probe.
+const changesQuery = `
+	SELECT time, target, probe, check_type, success, samples, failed
+	FROM (
+		SELECT time, target, COALESCE(probe, '') AS probe, check_type, success,
+			LAG(success) OVER (PARTITION BY target, COALESCE(probe, ''), check_type ORDER BY time) AS previous,
+			COUNT(*) OVER (PARTITION BY target) AS samples,
+			SUM(CASE WHEN success THEN 0 ELSE 1 END) OVER (PARTITION BY target) AS failed
+		FROM metrics
+		WHERE time >= %[1]s AND time < %[2]s
+		AND (%[3]s = '' OR target = %[3]s)
+	) AS outcomes
+	WHERE previous IS NULL OR previous <> success
+	ORDER BY target, time ASC
+`
+
+// addChange adds a sample returned by changesQuery to its target's
+// summary.
+func addChange(changes map[string]SampleChanges, sample Sample, samples, failed int) {
+	c := changes[sample.Target]
+	c.Samples, c.FailedSamples = samples, failed
+	c.Changes = append(c.Changes, sample)
+	changes[sample.Target] = c
+}
+
+// nullableJSON stores an absent JSON document as NULL.
+func nullableJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
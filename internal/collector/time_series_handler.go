@@ -3,75 +3,138 @@ package collector
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
 )
 
-func TimeSeriesHandler(db *pgxpool.Pool) http.HandlerFunc {
+const (
+	defaultMaxPoints = 1000
+	maxPointsLimit   = 10000
+)
+
+func TimeSeriesHandler(db store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Parse query parameters
-		target := r.URL.Query().Get("target")
-		checkType := r.URL.Query().Get("check_type")
-		duration := r.URL.Query().Get("duration")
+		q := r.URL.Query()
+		query := store.SeriesQuery{
+			Target:    q.Get("target"),
+			CheckType: q.Get("check_type"),
+		}
 
-		// Set default duration if not provided
+		// Resolve the time range from start/end, falling back to the last duration
+		duration := q.Get("duration")
 		if duration == "" {
 			duration = "1h" // Default to last 1 hour
 		}
-
-		// Parse duration
-		dur, err := time.ParseDuration(duration)
+		dur, err := config.ParseDuration(duration)
 		if err != nil {
 			http.Error(w, "Invalid duration", http.StatusBadRequest)
 			return
 		}
+		query.End = time.Now()
+		if end := q.Get("end"); end != "" {
+			if query.End, err = time.Parse(time.RFC3339, end); err != nil {
+				http.Error(w, "Invalid end", http.StatusBadRequest)
+				return
+			}
+		}
+		query.Start = query.End.Add(-dur)
+		if start := q.Get("start"); start != "" {
+			if query.Start, err = time.Parse(time.RFC3339, start); err != nil {
+				http.Error(w, "Invalid start", http.StatusBadRequest)
+				return
+			}
+		}
+		if !query.End.After(query.Start) {
+			http.Error(w, "end must be after start", http.StatusBadRequest)
+			return
+		}
+
+		maxPoints := defaultMaxPoints
+		if mp := q.Get("max_points"); mp != "" {
+			maxPoints, err = strconv.Atoi(mp)
+			if err != nil || maxPoints <= 0 {
+				http.Error(w, "Invalid max_points", http.StatusBadRequest)
+				return
+			}
+		}
+		if maxPoints > maxPointsLimit {
+			maxPoints = maxPointsLimit
+		}
+
+		// Without a step, return the most recent raw rows
+		if q.Get("step") == "" {
+			query.Limit = maxPoints + 1
+			results, err := db.Raw(r.Context(), query)
+			if err != nil {
+				http.Error(w, "Failed to fetch time series data", http.StatusInternalServerError)
+				return
+			}
+			if len(results) > maxPoints {
+				results = results[len(results)-maxPoints:]
+				w.Header().Set("X-Ekolod-Truncated", "true")
+			}
+			points := make([]map[string]interface{}, 0, len(results))
+			for _, result := range results {
+				point := map[string]interface{}{
+					"time":       result.Time,
+					"target":     result.Target,
+					"check_type": result.Check,
+					"duration":   result.Duration,
+					"success":    result.Success,
+				}
+				if len(result.Assertions) > 0 {
+					point["assertions"] = result.Assertions
+				}
+				if len(result.Steps) > 0 {
+					point["steps"] = result.Steps
+				}
+				if len(result.Redirects) > 0 {
+					point["redirects"] = result.Redirects
+				}
+				points = append(points, point)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(points)
+			return
+		}
+
+		query.Step, err = config.ParseDuration(q.Get("step"))
+		if err != nil || query.Step <= 0 {
+			http.Error(w, "Invalid step", http.StatusBadRequest)
+			return
+		}
 
-		// Calculate time range
-		endTime := time.Now()
-		startTime := endTime.Add(-dur)
+		query.Step = capStep(query.Start, query.End, query.Step, maxPoints)
 
-		// Construct and execute the query
-		query := `
-			SELECT time, target, check_type, duration, success
-			FROM metrics
-			WHERE time BETWEEN $1 AND $2
-			AND ($3 = '' OR target = $3)
-			AND ($4 = '' OR check_type = $4)
-			ORDER BY time ASC
-		`
-		rows, err := db.Query(r.Context(), query, startTime, endTime, target, checkType)
+		query.Aggregates, err = store.ParseAggregates(q.Get("aggregates"))
 		if err != nil {
-			http.Error(w, "Failed to fetch time series data", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer rows.Close()
 
-		// Process the results
-		var results []map[string]interface{}
-		for rows.Next() {
-			var (
-				timestamp time.Time
-				target    string
-				checkType string
-				duration  float64
-				success   bool
-			)
-			if err := rows.Scan(&timestamp, &target, &checkType, &duration, &success); err != nil {
-				http.Error(w, "Failed to process time series data", http.StatusInternalServerError)
-				return
-			}
-			results = append(results, map[string]interface{}{
-				"time":       timestamp,
-				"target":     target,
-				"check_type": checkType,
-				"duration":   duration,
-				"success":    success,
-			})
+		series, err := db.Buckets(r.Context(), query)
+		if err != nil {
+			http.Error(w, "Failed to fetch time series data", http.StatusInternalServerError)
+			return
 		}
 
-		// Send the response
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(results)
+		w.Header().Set("X-Ekolod-Step", series.Step.String())
+		w.Header().Set("X-Ekolod-Source", series.Source)
+		json.NewEncoder(w).Encode(series.Points)
+	}
+}
+
+// capStep widens step so that no series returns more than maxPoints buckets.
+func capStep(start, end time.Time, step time.Duration, maxPoints int) time.Duration {
+	span := end.Sub(start)
+	if int64(span/step) < int64(maxPoints) {
+		return step
 	}
+	minStep := span / time.Duration(maxPoints)
+	// Round up to a whole second so buckets stay aligned.
+	return (minStep/time.Second + 1) * time.Second
 }
@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+)
+
+type UptimeReport struct {
+	Target string `json:"target"`
+	Window Window `json:"window"`
+	// RetainedFrom is set when the window starts before the oldest
+	// retained results. The report then only covers the time since.
+	RetainedFrom        *time.Time `json:"retained_from,omitempty"`
+	AvailabilityPercent *float64   `json:"availability_percent"`
+	DowntimeSeconds     float64    `json:"downtime_seconds"`
+	Incidents           int        `json:"incidents"`
+	Ongoing             bool       `json:"ongoing"`
+	MTTRSeconds         *float64   `json:"mttr_seconds"`
+	MTBFSeconds         *float64   `json:"mtbf_seconds"`
+	Samples             int        `json:"samples"`
+	FailedSamples       int        `json:"failed_samples"`
+}
+
+// ComputeUptime derives availability from the changes in a target's
+// samples. The target counts as down from the first sample where a probe
+// sees any of its checks fail until every check passes again at every
+// probe. Each probe keeps its own view of a check, so probes that disagree
+// don't flip the state with every sample. Time before the first sample is
+// treated as unobserved rather than up.
+func ComputeUptime(target string, samples store.SampleChanges, window Window, now time.Time) UptimeReport {
+	report := UptimeReport{Target: target, Window: window, Samples: samples.Samples, FailedSamples: samples.FailedSamples}
+	end := window.observedEnd(now)
+
+	// failing holds the probe and check pairs whose last sample failed
+	failing := make(map[string]bool)
+	var (
+		first     time.Time
+		down      bool
+		downSince time.Time
+		downtime  time.Duration
+		repairs   []time.Duration
+	)
+
+	for _, s := range samples.Changes {
+		if first.IsZero() {
+			first = s.Time
+		}
+
+		key := s.Probe + "\x00" + s.Check
+		if s.Success {
+			delete(failing, key)
+		} else {
+			failing[key] = true
+		}
+
+		switch {
+		case len(failing) > 0 && !down:
+			down = true
+			downSince = s.Time
+			report.Incidents++
+		case len(failing) == 0 && down:
+			down = false
+			repair := s.Time.Sub(downSince)
+			downtime += repair
+			repairs = append(repairs, repair)
+		}
+	}
+
+	if len(samples.Changes) == 0 {
+		return report
+	}
+
+	if down {
+		report.Ongoing = true
+		if end.After(downSince) {
+			downtime += end.Sub(downSince)
+		}
+	}
+	report.DowntimeSeconds = downtime.Seconds()
+
+	observed := end.Sub(first)
+	if observed > 0 {
+		availability := float64(observed-downtime) / float64(observed) * 100
+		report.AvailabilityPercent = &availability
+	}
+
+	if len(repairs) > 0 {
+		var total time.Duration
+		for _, r := range repairs {
+			total += r
+		}
+		mttr := (total / time.Duration(len(repairs))).Seconds()
+		report.MTTRSeconds = &mttr
+	}
+
+	if report.Incidents > 0 && observed > 0 {
+		mtbf := (observed - downtime).Seconds() / float64(report.Incidents)
+		report.MTBFSeconds = &mtbf
+	}
+
+	return report
+}
+
+type ErrorBudget struct {
+	AllowedSeconds   float64 `json:"allowed_seconds"`
+	ConsumedSeconds  float64 `json:"consumed_seconds"`
+	RemainingSeconds float64 `json:"remaining_seconds"`
+	RemainingPercent float64 `json:"remaining_percent"`
+}
+
+type SLOReport struct {
+	Target      string       `json:"target"`
+	Objective   float64      `json:"objective"`
+	ErrorBudget ErrorBudget  `json:"error_budget"`
+	Met         bool         `json:"met"`
+	Uptime      UptimeReport `json:"uptime"`
+}
+
+// ComputeSLO measures an uptime report against an availability objective.
+// The error budget covers the whole window, so a calendar month in progress
+// is budgeted for the full month.
+func ComputeSLO(objective float64, uptime UptimeReport) SLOReport {
+	allowed := (1 - objective/100) * uptime.Window.Duration().Seconds()
+	budget := ErrorBudget{
+		AllowedSeconds:   allowed,
+		ConsumedSeconds:  uptime.DowntimeSeconds,
+		RemainingSeconds: allowed - uptime.DowntimeSeconds,
+	}
+	if allowed > 0 {
+		budget.RemainingPercent = budget.RemainingSeconds / allowed * 100
+	}
+
+	met := budget.RemainingSeconds >= 0
+	if uptime.AvailabilityPercent == nil {
+		met = false
+	}
+
+	return SLOReport{
+		Target:      uptime.Target,
+		Objective:   objective,
+		ErrorBudget: budget,
+		Met:         met,
+		Uptime:      uptime,
+	}
+}
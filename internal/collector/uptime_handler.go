@@ -0,0 +1,148 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+)
+
+const defaultReportWindow = "30d"
+
+func UptimeHandler(db store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		window, err := windowFromQuery(r.URL.Query(), defaultReportWindow)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		retainedFrom := retainedStart(db, window, now)
+		samples, err := fetchSamples(r.Context(), db, window, retainedFrom, r.URL.Query().Get("target"))
+		if err != nil {
+			http.Error(w, "Failed to fetch uptime data", http.StatusInternalServerError)
+			return
+		}
+
+		reports := []UptimeReport{}
+		for _, target := range sortedTargets(samples) {
+			report := ComputeUptime(target, samples[target], window, now)
+			report.RetainedFrom = retainedFrom
+			reports = append(reports, report)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	}
+}
+
+func SLOHandler(db store.Store, slos []config.SLO) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		target := r.URL.Query().Get("target")
+		reports := []SLOReport{}
+		now := time.Now()
+		for _, slo := range slos {
+			if target != "" && slo.Target != target {
+				continue
+			}
+
+			fallback := slo.Window
+			if fallback == "" {
+				fallback = defaultReportWindow
+			}
+			window, err := windowFromQuery(r.URL.Query(), fallback)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			retainedFrom := retainedStart(db, window, now)
+			samples, err := fetchSamples(r.Context(), db, window, retainedFrom, slo.Target)
+			if err != nil {
+				http.Error(w, "Failed to fetch uptime data", http.StatusInternalServerError)
+				return
+			}
+
+			uptime := ComputeUptime(slo.Target, samples[slo.Target], window, now)
+			uptime.RetainedFrom = retainedFrom
+			reports = append(reports, ComputeSLO(slo.Objective, uptime))
+		}
+
+		if target != "" && len(reports) == 0 {
+			http.Error(w, "No SLO configured for target", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	}
+}
+
+// ValidateSLOs checks SLO definitions at startup so mistakes don't surface
+// as request errors later.
+func ValidateSLOs(slos []config.SLO) error {
+	for _, slo := range slos {
+		if slo.Target == "" {
+			return fmt.Errorf("slo is missing a target")
+		}
+		if slo.Objective <= 0 || slo.Objective > 100 {
+			return fmt.Errorf("slo for %q: objective must be in (0, 100], got %v", slo.Target, slo.Objective)
+		}
+		if slo.Window != "" {
+			if _, err := ParseWindow(slo.Window, time.Now()); err != nil {
+				return fmt.Errorf("slo for %q: %w", slo.Target, err)
+			}
+		}
+	}
+	return nil
+}
+
+// retainedStart returns when the retained results start if the window
+// starts before, and nil otherwise. Uptime is computed from individual
+// results, which rollups don't keep, so older time can't be accounted for.
+func retainedStart(db store.Store, window Window, now time.Time) *time.Time {
+	retention := db.RawRetention()
+	if retention == 0 {
+		return nil
+	}
+	start := now.Add(-retention).UTC()
+	if !window.Start.Before(start) {
+		return nil
+	}
+	return &start
+}
+
+// fetchSamples returns the changes in the samples in the window, or since
+// retainedFrom if it is set, by target.
+func fetchSamples(ctx context.Context, db store.Store, window Window, retainedFrom *time.Time, target string) (map[string]store.SampleChanges, error) {
+	start := window.Start
+	if retainedFrom != nil {
+		start = *retainedFrom
+	}
+	return db.Changes(ctx, target, start, window.End)
+}
+
+func sortedTargets(m map[string]store.SampleChanges) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
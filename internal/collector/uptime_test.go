@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/collector/store"
+)
+
+func TestUptimeTracksProbesSeparately(t *testing.T) {
+	ctx := context.Background()
+	db := openTestStore(t)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	insert := func(minute int, probe, check string, success bool) {
+		t.Helper()
+		result := store.Result{Time: start.Add(time.Duration(minute) * time.Minute), Target: "api", Probe: probe, Check: check, Success: success}
+		if err := db.Insert(ctx, result); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+
+	// eu can't reach the target from minute 10 to 30 while us always
+	// can; /health passes throughout
+	for minute := 0; minute < 60; minute++ {
+		insert(minute, "us", "/", true)
+		insert(minute, "eu", "/", minute < 10 || minute >= 30)
+		insert(minute, "eu", "/health", true)
+	}
+
+	window := Window{Start: start, End: start.Add(time.Hour)}
+	changes, err := db.Changes(ctx, "", window.Start, window.End)
+	if err != nil {
+		t.Fatalf("Changes: %v", err)
+	}
+	samples := changes["api"]
+	if samples.Samples != 180 || samples.FailedSamples != 20 {
+		t.Errorf("samples = %d, failed = %d, want 180 and 20", samples.Samples, samples.FailedSamples)
+	}
+	// The first sample of each probe and check, and eu going down and up
+	if len(samples.Changes) != 5 {
+		t.Errorf("got %d changes, want 5: %+v", len(samples.Changes), samples.Changes)
+	}
+
+	report := ComputeUptime("api", samples, window, window.End)
+	if report.Incidents != 1 {
+		t.Errorf("incidents = %d, want 1", report.Incidents)
+	}
+	if want := (20 * time.Minute).Seconds(); report.DowntimeSeconds != want {
+		t.Errorf("downtime = %vs, want %vs", report.DowntimeSeconds, want)
+	}
+}
@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (w Window) Duration() time.Duration {
+	return w.End.Sub(w.Start)
+}
+
+// observedEnd is the end of the window clipped to now, since calendar
+// windows may extend into the future.
+func (w Window) observedEnd(now time.Time) time.Time {
+	if w.End.After(now) {
+		return now
+	}
+	return w.End
+}
+
+// ParseWindow resolves a window expression relative to now. It accepts Go
+// durations (12h), days (7d, 30d), "month" for the current calendar month
+// and YYYY-MM for a specific calendar month. Calendar windows always span
+// the whole month, even when it hasn't ended yet.
+func ParseWindow(expr string, now time.Time) (Window, error) {
+	now = now.UTC()
+	switch {
+	case expr == "month":
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return Window{Start: start, End: start.AddDate(0, 1, 0)}, nil
+	case len(expr) == 7 && expr[4] == '-':
+		start, err := time.Parse("2006-01", expr)
+		if err != nil {
+			return Window{}, fmt.Errorf("invalid month %q: %w", expr, err)
+		}
+		return Window{Start: start, End: start.AddDate(0, 1, 0)}, nil
+	case strings.HasSuffix(expr, "d"):
+		days, err := strconv.Atoi(strings.TrimSuffix(expr, "d"))
+		if err != nil || days <= 0 {
+			return Window{}, fmt.Errorf("invalid window %q", expr)
+		}
+		return Window{Start: now.AddDate(0, 0, -days), End: now}, nil
+	default:
+		d, err := time.ParseDuration(expr)
+		if err != nil || d <= 0 {
+			return Window{}, fmt.Errorf("invalid window %q", expr)
+		}
+		return Window{Start: now.Add(-d), End: now}, nil
+	}
+}
+
+// windowFromQuery reads either explicit start/end (RFC 3339) or a window
+// expression from the query string.
+func windowFromQuery(q url.Values, fallback string) (Window, error) {
+	now := time.Now()
+	if q.Get("start") != "" || q.Get("end") != "" {
+		w := Window{Start: now.Add(-24 * time.Hour), End: now}
+		if s := q.Get("start"); s != "" {
+			start, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return Window{}, fmt.Errorf("invalid start: %w", err)
+			}
+			w.Start = start.UTC()
+		}
+		if e := q.Get("end"); e != "" {
+			end, err := time.Parse(time.RFC3339, e)
+			if err != nil {
+				return Window{}, fmt.Errorf("invalid end: %w", err)
+			}
+			w.End = end.UTC()
+		}
+		if !w.End.After(w.Start) {
+			return Window{}, fmt.Errorf("end must be after start")
+		}
+		return w, nil
+	}
+
+	expr := q.Get("window")
+	if expr == "" {
+		expr = fallback
+	}
+	return ParseWindow(expr, now)
+}
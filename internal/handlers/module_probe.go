@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/internal/probe"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	defaultModuleTimeout = 10 * time.Second
+	// Leaves Prometheus time to receive the response before its scrape
+	// times out.
+	scrapeTimeoutOffset = 500 * time.Millisecond
+)
+
+// ModuleProbeHandler serves /probe?target=...&module=... in the style of the
+// Prometheus blackbox exporter. The module's check runs against target while
+// the request waits, and the outcome is returned as metrics registered for
+// this request only.
+func ModuleProbeHandler(probe probe.Probe) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := r.URL.Query()
+		moduleName := q.Get("module")
+		if moduleName == "" {
+			moduleName = "http_2xx"
+		}
+		mu.Lock()
+		var (
+			module config.Module
+			found  bool
+		)
+		if cfg != nil {
+			module, found = cfg.Modules[moduleName]
+		}
+		mu.Unlock()
+		if !found {
+			http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+			return
+		}
+
+		target, err := normalizeProbeTarget(q.Get("target"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), moduleTimeout(r, module))
+		defer cancel()
+
+		result := probe.ProbeModule(ctx, target, module)
+
+		success := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_success",
+			Help: "Whether the probe succeeded.",
+		})
+		duration := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "How long the probe took to complete, in seconds.",
+		})
+		statusCode := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_http_status_code",
+			Help: "HTTP status code of the response, 0 if there was none.",
+		})
+		contentLength := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_http_content_length",
+			Help: "Content length of the response, -1 if unknown.",
+		})
+		redirects := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_http_redirects",
+			Help: "Number of redirects that were followed.",
+		})
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(success, duration, statusCode, contentLength, redirects)
+
+		if result.Success {
+			success.Set(1)
+		}
+		duration.Set(result.Duration)
+		statusCode.Set(float64(result.StatusCode))
+		contentLength.Set(float64(result.ContentLength))
+		redirects.Set(float64(len(result.Redirects)))
+
+		if result.TLSVersion != "" {
+			tlsVersion := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "probe_tls_version_info",
+				Help: "TLS version used for the connection.",
+			}, []string{"version"})
+			certExpiry := prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "probe_ssl_cert_expiry_days",
+				Help: "Number of days until the server certificate expires.",
+			})
+			registry.MustRegister(tlsVersion, certExpiry)
+			tlsVersion.WithLabelValues(result.TLSVersion).Set(1)
+			certExpiry.Set(float64(result.CertExpiryDays))
+		}
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+// normalizeProbeTarget accepts a URL or a bare host, which is probed over
+// plain HTTP.
+func normalizeProbeTarget(target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("target parameter is missing")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid target %q", target)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// moduleTimeout is the module's timeout, shortened to fit within the scrape
+// timeout Prometheus announces.
+func moduleTimeout(r *http.Request, module config.Module) time.Duration {
+	timeout := module.Timeout
+	if timeout <= 0 {
+		timeout = defaultModuleTimeout
+	}
+	if header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); header != "" {
+		if seconds, err := strconv.ParseFloat(header, 64); err == nil && seconds > 0 {
+			scrape := time.Duration(seconds*float64(time.Second)) - scrapeTimeoutOffset
+			if scrape > 0 && scrape < timeout {
+				timeout = scrape
+			}
+		}
+	}
+	return timeout
+}
@@ -5,8 +5,11 @@ import (
 	"sync"
 
 	"github.com/c-j-p-nordquist/ekolod/internal/probe"
+	"github.com/c-j-p-nordquist/ekolod/pkg/checkconverter"
 	"github.com/c-j-p-nordquist/ekolod/pkg/config"
 	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+	"github.com/c-j-p-nordquist/ekolod/pkg/metrics"
+	"github.com/c-j-p-nordquist/ekolod/pkg/secrets"
 )
 
 var (
@@ -15,12 +18,15 @@ var (
 )
 
 func Init(cfgPath string, probe probe.Probe) {
-	var err error
-	cfg, err = config.LoadConfig(cfgPath)
+	loaded, err := config.LoadConfig(cfgPath)
+	if err == nil {
+		err = checkconverter.Validate(loaded)
+	}
 	if err != nil {
-		logging.Error(err)
+		logging.Error("Failed to load config", logging.Err(err))
 		return
 	}
+	cfg = loaded
 	updateProbeAndTargetList(probe)
 }
 
@@ -37,13 +43,15 @@ func updateProbeAndTargetList(probe probe.Probe) {
 		found := false
 		for _, oldTarget := range oldTargets {
 			if oldTarget.Name == newTarget.Name {
-				probe.UpdateTargetChecks(newTarget.Name, newTarget.Checks)
+				probe.UpdateTarget(newTarget)
 				found = true
 				break
 			}
 		}
 		if !found {
-			probe.AddTarget(newTarget)
+			if err := probe.AddTarget(newTarget); err != nil {
+				logging.Error("Failed to add target", logging.KeyTarget, newTarget.Name, logging.Err(err))
+			}
 		}
 	}
 
@@ -77,15 +85,24 @@ func ReloadHandler(probe probe.Probe) http.HandlerFunc {
 		mu.Lock()
 		defer mu.Unlock()
 
-		var err error
-		cfg, err = config.LoadConfig("configs/config.yaml")
+		loaded, err := config.LoadConfig("configs/config.yaml")
 		if err != nil {
-			logging.Error(err)
+			metrics.ConfigReloads.WithLabelValues("failure").Inc()
+			logging.Error("Failed to reload config", logging.Err(err))
 			http.Error(w, "Failed to reload config", http.StatusInternalServerError)
 			return
 		}
+		// Keep running the current configuration if the new one is invalid
+		if err := checkconverter.Validate(loaded); err != nil {
+			metrics.ConfigReloads.WithLabelValues("failure").Inc()
+			logging.Error("Rejected invalid config", logging.Err(err))
+			http.Error(w, "Invalid config: "+secrets.Redact(err.Error()), http.StatusBadRequest)
+			return
+		}
 
+		cfg = loaded
 		updateProbeAndTargetList(probe)
+		metrics.ConfigReloads.WithLabelValues("success").Inc()
 
 		logging.Info("Configuration reloaded successfully")
 		w.Write([]byte("Configuration reloaded successfully"))
@@ -6,8 +6,10 @@ import (
 	"sync"
 
 	"github.com/c-j-p-nordquist/ekolod/internal/probe"
+	"github.com/c-j-p-nordquist/ekolod/pkg/checkconverter"
 	"github.com/c-j-p-nordquist/ekolod/pkg/config"
 	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+	"github.com/c-j-p-nordquist/ekolod/pkg/secrets"
 )
 
 var (
@@ -24,7 +26,13 @@ func ListTargetsHandler() http.HandlerFunc {
 		targetMu.Lock()
 		defer targetMu.Unlock()
 
-		json.NewEncoder(w).Encode(targetList)
+		// Header values are redacted by config.Secret, URL passwords here
+		targets := make([]config.Target, len(targetList))
+		for i, target := range targetList {
+			target.URL = secrets.RedactURL(target.URL)
+			targets[i] = target
+		}
+		json.NewEncoder(w).Encode(targets)
 	}
 }
 
@@ -36,11 +44,19 @@ func AddTargetHandler(probe probe.Probe) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		target.RegisterSecrets()
+		if err := checkconverter.ValidateTarget(target); err != nil {
+			http.Error(w, secrets.Redact(err.Error()), http.StatusBadRequest)
+			return
+		}
 
 		targetMu.Lock()
 		defer targetMu.Unlock()
 
-		probe.AddTarget(&target)
+		if err := probe.AddTarget(&target); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		targetList = probe.GetTargets() // Update targetList with the new targets
 
 		// Update the global cfg variable
@@ -48,7 +64,7 @@ func AddTargetHandler(probe probe.Probe) http.HandlerFunc {
 		cfg.Targets = append(cfg.Targets, target)
 		mu.Unlock()
 
-		logging.Info("Added new target: " + target.Name)
+		logging.Info("Added new target", logging.KeyTarget, target.Name)
 
 		w.WriteHeader(http.StatusCreated)
 	}
@@ -81,7 +97,7 @@ func RemoveTargetHandler(probe probe.Probe) http.HandlerFunc {
 		}
 		mu.Unlock()
 
-		logging.Info("Removed target: " + target.Name)
+		logging.Info("Removed target", logging.KeyTarget, target.Name)
 		w.WriteHeader(http.StatusOK)
 	}
 }
@@ -0,0 +1,49 @@
+package probe
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+)
+
+func TestUnauthorizedInvalidatesToken(t *testing.T) {
+	var issued atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": 3600}`, issued.Add(1))
+	}))
+	defer tokenServer.Close()
+
+	// The first token is revoked before it expires
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer api.Close()
+
+	t.Setenv("EKOLOD_TEST_CLIENT_SECRET", "s3cret")
+	target := testTarget("api", api.URL, "/")
+	target.Frequency = time.Hour
+	target.Auth = &config.AuthConfig{OAuth2: &config.OAuth2Config{
+		TokenURL:     tokenServer.URL,
+		ClientID:     "client",
+		ClientSecret: config.SecretRef{Env: "EKOLOD_TEST_CLIENT_SECRET"},
+	}}
+	p := newTestProbe(t, target)
+
+	if result := p.runCheck(target, target.Checks[0]); result.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("first run status = %d, want 401", result.StatusCode)
+	}
+	if result := p.runCheck(target, target.Checks[0]); result.StatusCode != http.StatusOK {
+		t.Errorf("run after 401 status = %d, want 200 with a new token", result.StatusCode)
+	}
+	if n := issued.Load(); n != 2 {
+		t.Errorf("requested %d tokens, want 2", n)
+	}
+}
@@ -1,6 +1,9 @@
 package probe
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
@@ -8,15 +11,49 @@ import (
 	"github.com/c-j-p-nordquist/ekolod/pkg/metricspusher"
 )
 
+const (
+	// The last run is stale once no check completed for this many times
+	// the longest check frequency.
+	lastRunStaleness = 3
+	// The scheduler is stalled once a check is this far past its run time.
+	schedulerStallThreshold = 5 * time.Minute
+)
+
+// LastRunChecker fails when the probe has checks to run but none has
+// completed recently. Every completed run counts, whatever its outcome, so
+// that a probe whose targets are all down keeps reporting.
 type LastRunChecker struct {
 	lastRun time.Time
-	mu      sync.RWMutex
+	started time.Time
+	// interval returns the longest frequency of the scheduled checks, or 0
+	// if there are none. It is set when the probe starts.
+	interval func() time.Duration
+	mu       sync.RWMutex
 }
 
-func (c *LastRunChecker) Check() bool {
+func (c *LastRunChecker) Check(ctx context.Context) error {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return !c.lastRun.IsZero()
+	lastRun, started, interval := c.lastRun, c.started, c.interval
+	c.mu.RUnlock()
+
+	if interval == nil {
+		return errors.New("probe has not started")
+	}
+	expected := interval()
+	if expected == 0 {
+		return nil
+	}
+	since := lastRun
+	if since.IsZero() {
+		since = started
+	}
+	if age := time.Since(since); age > lastRunStaleness*expected {
+		if lastRun.IsZero() {
+			return fmt.Errorf("no check has completed in %s", age.Round(time.Second))
+		}
+		return fmt.Errorf("last check completed %s ago", age.Round(time.Second))
+	}
+	return nil
 }
 
 func (c *LastRunChecker) Name() string {
@@ -29,15 +66,46 @@ func (c *LastRunChecker) UpdateLastRun() {
 	c.mu.Unlock()
 }
 
+func (c *LastRunChecker) setInterval(interval func() time.Duration) {
+	c.mu.Lock()
+	c.interval = interval
+	c.started = time.Now()
+	c.mu.Unlock()
+}
+
+// SchedulerChecker fails when due checks aren't handed to the workers, e.g.
+// because every worker is stuck.
+type SchedulerChecker struct {
+	scheduler *scheduler
+}
+
+func (c *SchedulerChecker) Check(ctx context.Context) error {
+	if late := c.scheduler.overdue(time.Now()); late > schedulerStallThreshold {
+		return fmt.Errorf("next check is %s overdue", late.Round(time.Second))
+	}
+	return nil
+}
+
+func (c *SchedulerChecker) Name() string {
+	return "scheduler"
+}
+
 type CollectorReachableChecker struct{}
 
-func (c *CollectorReachableChecker) Check() bool {
-	resp, err := http.Get(metricspusher.GetCollectorURL() + "/health")
+func (c *CollectorReachableChecker) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metricspusher.GetCollectorURL()+"/health", nil)
 	if err != nil {
-		return false
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector responded with status code: %d", resp.StatusCode)
+	}
+	return nil
 }
 
 func (c *CollectorReachableChecker) Name() string {
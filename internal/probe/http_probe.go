@@ -1,14 +1,20 @@
 package probe
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/c-j-p-nordquist/ekolod/pkg/checkconverter"
 	"github.com/c-j-p-nordquist/ekolod/pkg/checker"
 	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/events"
 	"github.com/c-j-p-nordquist/ekolod/pkg/httputils"
 	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
 	"github.com/c-j-p-nordquist/ekolod/pkg/metrics"
@@ -17,81 +23,133 @@ import (
 	"github.com/c-j-p-nordquist/ekolod/pkg/tlsutils"
 )
 
+// ErrTargetExists is returned when adding a target whose name is taken.
+var ErrTargetExists = errors.New("target already exists")
+
+type Options struct {
+	Events     *events.Broker
+	Scheduler  config.SchedulerConfig
+	HTTPClient config.HTTPClientConfig
+}
+
+// HTTPProbe never performs network I/O while holding mu. mu guards the
+// target list; results are published copy-on-write so readers never block on
+// a probe cycle.
 type HTTPProbe struct {
 	targets        []*config.Target
-	mu             sync.Mutex
-	metrics        map[string]map[string]*proberesult.ProbeResult
-	stopChannels   map[string]chan struct{}
+	mu             sync.RWMutex
+	results        atomic.Pointer[resultSet]
+	resultsMu      sync.Mutex
 	lastRunChecker *LastRunChecker
+	states         *stateTracker
+	events         *events.Broker
+	scheduler      *scheduler
+	clients        *httputils.ClientPool
+	tokens         *httputils.TokenCache
+	clientConfig   config.HTTPClientConfig
 }
 
-func NewHTTPProbe(targets []*config.Target, lastRunChecker *LastRunChecker) *HTTPProbe {
+// resultSet maps target name and check path to the latest result. A
+// published resultSet is never modified.
+type resultSet map[string]map[string]*proberesult.ProbeResult
+
+func NewHTTPProbe(targets []*config.Target, lastRunChecker *LastRunChecker, opts Options) *HTTPProbe {
 	probe := &HTTPProbe{
 		targets:        targets,
-		metrics:        make(map[string]map[string]*proberesult.ProbeResult),
-		stopChannels:   make(map[string]chan struct{}),
 		lastRunChecker: lastRunChecker,
+		states:         newStateTracker(),
+		events:         opts.Events,
+		clients:        httputils.NewClientPool(),
+		tokens:         httputils.NewTokenCache(),
+		clientConfig:   opts.HTTPClient,
 	}
+	probe.results.Store(&resultSet{})
+	probe.scheduler = newScheduler(opts.Scheduler, probe.runJob)
+	lastRunChecker.setInterval(probe.scheduler.longestFrequency)
 	probe.Start()
 	return probe
 }
 
 func (p *HTTPProbe) Start() {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 
 	for _, target := range p.targets {
-		if _, exists := p.stopChannels[target.Name]; !exists {
-			stopChan := make(chan struct{})
-			p.stopChannels[target.Name] = stopChan
-			go p.probeTarget(target, stopChan)
-		}
+		p.scheduler.add(target)
 	}
+	p.scheduler.start()
 }
 
 func (p *HTTPProbe) Stop() {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.scheduler.shutdown()
+	p.scheduler.clear()
+	p.clients.CloseIdleConnections()
+}
 
-	for _, stopChan := range p.stopChannels {
-		close(stopChan)
+// Shutdown stops scheduling new checks and waits for in-flight checks to
+// finish or for ctx to be done, whichever comes first.
+func (p *HTTPProbe) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.scheduler.shutdown()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.clients.CloseIdleConnections()
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for in-flight checks: %w", ctx.Err())
 	}
-	p.stopChannels = make(map[string]chan struct{})
+}
+
+// SchedulerChecker reports on the progress of the scheduler, for liveness.
+func (p *HTTPProbe) SchedulerChecker() *SchedulerChecker {
+	return &SchedulerChecker{scheduler: p.scheduler}
 }
 
 func (p *HTTPProbe) UpdateTargets(targets []*config.Target) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for name, stopChan := range p.stopChannels {
+	previous := make(map[string]*config.Target, len(p.targets))
+	for _, old := range p.targets {
+		previous[old.Name] = old
 		found := false
 		for _, target := range targets {
-			if target.Name == name {
+			if target.Name == old.Name {
 				found = true
 				break
 			}
 		}
 		if !found {
-			close(stopChan)
-			delete(p.stopChannels, name)
-			delete(p.metrics, name)
+			p.forgetLocked(old.Name)
 		}
 	}
 
-	for _, target := range targets {
-		if _, exists := p.stopChannels[target.Name]; !exists {
-			stopChan := make(chan struct{})
-			p.stopChannels[target.Name] = stopChan
-			go p.probeTarget(target, stopChan)
+	// Unchanged targets keep their running definition
+	updated := make([]*config.Target, len(targets))
+	for i, target := range targets {
+		old := previous[target.Name]
+		switch {
+		case old == nil:
+			p.scheduler.add(target)
+			updated[i] = target
+		case old == target || reflect.DeepEqual(old, target):
+			updated[i] = old
+		default:
+			p.replaceLocked(old, target)
+			updated[i] = target
 		}
 	}
 
-	p.targets = targets
+	p.targets = updated
 }
 
 func (p *HTTPProbe) GetTargets() []config.Target {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	targets := make([]config.Target, len(p.targets))
 	for i, t := range p.targets {
 		targets[i] = *t
@@ -100,11 +158,10 @@ func (p *HTTPProbe) GetTargets() []config.Target {
 }
 
 func (p *HTTPProbe) GetMetrics() map[string]map[string]*proberesult.ProbeResult {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	copy := make(map[string]map[string]*proberesult.ProbeResult)
-	for k, v := range p.metrics {
-		copy[k] = make(map[string]*proberesult.ProbeResult)
+	current := *p.results.Load()
+	copy := make(map[string]map[string]*proberesult.ProbeResult, len(current))
+	for k, v := range current {
+		copy[k] = make(map[string]*proberesult.ProbeResult, len(v))
 		for kk, vv := range v {
 			copy[k][kk] = vv
 		}
@@ -112,16 +169,21 @@ func (p *HTTPProbe) GetMetrics() map[string]map[string]*proberesult.ProbeResult
 	return copy
 }
 
-func (p *HTTPProbe) AddTarget(target *config.Target) {
+// AddTarget starts probing a new target. It returns ErrTargetExists if a
+// target with the same name is already probed; use UpdateTarget to change
+// it.
+func (p *HTTPProbe) AddTarget(target *config.Target) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.targets = append(p.targets, target)
-	if _, exists := p.stopChannels[target.Name]; !exists {
-		stopChan := make(chan struct{})
-		p.stopChannels[target.Name] = stopChan
-		go p.probeTarget(target, stopChan)
+	for _, t := range p.targets {
+		if t.Name == target.Name {
+			return fmt.Errorf("%w: %q", ErrTargetExists, target.Name)
+		}
 	}
+	p.targets = append(p.targets, target)
+	p.scheduler.add(target)
+	return nil
 }
 
 func (p *HTTPProbe) RemoveTarget(name string) {
@@ -131,122 +193,348 @@ func (p *HTTPProbe) RemoveTarget(name string) {
 	for i, t := range p.targets {
 		if t.Name == name {
 			p.targets = append(p.targets[:i], p.targets[i+1:]...)
-			if stopChan, exists := p.stopChannels[name]; exists {
-				close(stopChan)
-				delete(p.stopChannels, name)
-			}
-			delete(p.metrics, name)
+			p.forgetLocked(name)
 			break
 		}
 	}
 }
 
+// RunProbe runs every check once, ahead of its schedule, and waits for the
+// results. The runs go through the scheduler's workers, so they respect
+// max_concurrency and their results are pushed like scheduled ones.
 func (p *HTTPProbe) RunProbe() {
+	p.scheduler.runNow()
+}
+
+func (p *HTTPProbe) UpdateTargetChecks(name string, checks []config.Check) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for _, target := range p.targets {
-		for _, check := range target.Checks {
-			result := p.runCheck(target, checkconverter.ConvertConfigCheckToCheckerCheck(check))
-
-			if p.metrics[target.Name] == nil {
-				p.metrics[target.Name] = make(map[string]*proberesult.ProbeResult)
-			}
-			p.metrics[target.Name][check.Path] = result
-
-			metrics.UpdatePrometheusMetrics(target, check, result)
-
-			if !result.Success {
-				logging.Warn(fmt.Sprintf("Check failed for target '%s', path '%s': %s", target.Name, check.Path, result.Message))
+	for i, target := range p.targets {
+		if target.Name == name {
+			// Targets are shared with in-flight checks, so replace rather than mutate.
+			updated := *target
+			updated.Checks = checks
+			if reflect.DeepEqual(target, &updated) {
+				return
 			}
+			p.replaceLocked(target, &updated)
+			p.targets[i] = &updated
+			break
 		}
 	}
 }
 
-func (p *HTTPProbe) UpdateTargetChecks(name string, checks []config.Check) {
+// UpdateTarget replaces the definition of the target with the same name,
+// e.g. after its URL, auth or frequency changed in the configuration. An
+// unchanged definition is ignored, so reloading the configuration doesn't
+// disturb targets it didn't touch.
+func (p *HTTPProbe) UpdateTarget(target *config.Target) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for i, target := range p.targets {
-		if target.Name == name {
-			p.targets[i].Checks = checks
-			if stopChan, exists := p.stopChannels[name]; exists {
-				close(stopChan)
-				delete(p.stopChannels, name)
+	for i, old := range p.targets {
+		if old.Name == target.Name {
+			if reflect.DeepEqual(old, target) {
+				return
 			}
-			stopChan := make(chan struct{})
-			p.stopChannels[name] = stopChan
-			go p.probeTarget(p.targets[i], stopChan)
+			p.replaceLocked(old, target)
+			p.targets[i] = target
+			break
+		}
+	}
+}
+
+// replaceLocked reschedules the checks of a changed target. Checks that are
+// performed as before keep their state and in-flight results, and checks
+// keep their place in the schedule unless the frequency changed. The caller
+// must hold mu for writing and put updated in place of old.
+func (p *HTTPProbe) replaceLocked(old, updated *config.Target) {
+	forgetRemovedChecks(updated.Name, old.Checks, updated.Checks)
+	p.forgetToken(old, updated)
+
+	var changed []string
+	for _, check := range old.Checks {
+		if !sameCheck(old, updated, check) {
+			changed = append(changed, check.ID())
+		}
+	}
+	p.states.removeChecks(updated.Name, changed)
+	p.scheduler.add(updated)
+}
+
+// sameCheck reports whether updated still performs check of old the same
+// way, so that its results remain valid.
+func sameCheck(old, updated *config.Target, check config.Check) bool {
+	if old.URL != updated.URL || !reflect.DeepEqual(old.HTTPClient, updated.HTTPClient) || !reflect.DeepEqual(old.Auth, updated.Auth) {
+		return false
+	}
+	for _, c := range updated.Checks {
+		if c.ID() == check.ID() {
+			return reflect.DeepEqual(c, check)
+		}
+	}
+	return false
+}
+
+// forgetToken drops the cached OAuth2 token of old if updated authenticates
+// differently, so that changed credentials take effect with the next check.
+func (p *HTTPProbe) forgetToken(old, updated *config.Target) {
+	if old.Auth == nil || old.Auth.OAuth2 == nil || reflect.DeepEqual(old.Auth, updated.Auth) {
+		return
+	}
+	p.tokens.Invalidate(*old.Auth.OAuth2)
+}
+
+// runJob is called by scheduler workers for every due check.
+func (p *HTTPProbe) runJob(j *job) {
+	target, check := j.target, j.check
+	result := p.runCheck(target, check)
+
+	if !p.record(target, check, result) {
+		return
+	}
+
+	pusherResult := &metricspusher.ProbeResult{
+		Duration:       result.Duration,
+		Success:        result.Success,
+		Message:        result.Message,
+		StatusCode:     result.StatusCode,
+		ContentLength:  result.ContentLength,
+		TLSVersion:     result.TLSVersion,
+		CertExpiryDays: result.CertExpiryDays,
+		Assertions:     result.Assertions,
+		Steps:          result.Steps,
+		Redirects:      result.Redirects,
+	}
+
+	metricspusher.Enqueue(target, check, pusherResult)
+
+	logResult(target, check, result)
+}
+
+// logResult logs failed checks as warnings and passed ones at debug level.
+func logResult(target *config.Target, check config.Check, result *proberesult.ProbeResult) {
+	args := []any{
+		logging.KeyTarget, target.Name,
+		logging.KeyCheck, check.ID(),
+		logging.KeyStatusCode, result.StatusCode,
+		logging.KeyDuration, result.Duration,
+	}
+	if !result.Success {
+		logging.Warn("Check failed", append(args, "message", result.Message)...)
+		return
+	}
+	logging.Debug("Check passed", args...)
+}
+
+// record publishes a finished check run. It returns false, dropping the
+// result, if the target was removed or the check reconfigured while it ran.
+func (p *HTTPProbe) record(target *config.Target, check config.Check, result *proberesult.ProbeResult) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	current := false
+	for _, t := range p.targets {
+		if t.Name == target.Name {
+			current = t == target || sameCheck(target, t, check)
 			break
 		}
 	}
+	if !current {
+		return false
+	}
+
+	p.storeResult(target.Name, check.ID(), result)
+	metrics.UpdatePrometheusMetrics(target, check, result)
+	p.publishResult(target, check, result)
+	return true
+}
+
+func (p *HTTPProbe) storeResult(target, check string, result *proberesult.ProbeResult) {
+	p.resultsMu.Lock()
+	defer p.resultsMu.Unlock()
+
+	current := *p.results.Load()
+	next := make(resultSet, len(current)+1)
+	for k, v := range current {
+		next[k] = v
+	}
+	checks := make(map[string]*proberesult.ProbeResult, len(current[target])+1)
+	for k, v := range current[target] {
+		checks[k] = v
+	}
+	checks[check] = result
+	next[target] = checks
+	p.results.Store(&next)
 }
 
-func (p *HTTPProbe) probeTarget(target *config.Target, stopChan chan struct{}) {
-	ticker := time.NewTicker(target.Frequency)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-stopChan:
-			return
-		case <-ticker.C:
-			for _, check := range target.Checks {
-				result := p.runCheck(target, checkconverter.ConvertConfigCheckToCheckerCheck(check))
-
-				p.mu.Lock()
-				if p.metrics[target.Name] == nil {
-					p.metrics[target.Name] = make(map[string]*proberesult.ProbeResult)
-				}
-				p.metrics[target.Name][check.Path] = result
-				p.mu.Unlock()
-
-				metrics.UpdatePrometheusMetrics(target, check, result)
-
-				pusherResult := &metricspusher.ProbeResult{
-					Duration:       result.Duration,
-					Success:        result.Success,
-					Message:        result.Message,
-					StatusCode:     result.StatusCode,
-					ContentLength:  result.ContentLength,
-					TLSVersion:     result.TLSVersion,
-					CertExpiryDays: result.CertExpiryDays,
-				}
-
-				err := metricspusher.PushMetricsToCollector(target, check, pusherResult)
-				if err != nil {
-					logging.Error(fmt.Errorf("failed to push metrics for target '%s', path '%s': %v", target.Name, check.Path, err))
-				}
-
-				if !result.Success {
-					logging.Warn(fmt.Sprintf("Check failed for target '%s', path '%s': %s", target.Name, check.Path, result.Message))
-				}
+// forgetLocked unschedules a target and drops its results and state. The
+// caller must hold mu for writing.
+func (p *HTTPProbe) forgetLocked(name string) {
+	p.scheduler.remove(name)
+	p.states.remove(name)
+	metrics.DeleteTarget(name)
+
+	p.resultsMu.Lock()
+	defer p.resultsMu.Unlock()
+
+	current := *p.results.Load()
+	if _, exists := current[name]; !exists {
+		return
+	}
+	next := make(resultSet, len(current))
+	for k, v := range current {
+		if k != name {
+			next[k] = v
+		}
+	}
+	p.results.Store(&next)
+}
+
+// forgetRemovedChecks deletes the metrics of checks that a target no longer
+// has.
+func forgetRemovedChecks(target string, old, updated []config.Check) {
+	for _, check := range old {
+		kept := false
+		for _, c := range updated {
+			if c.ID() == check.ID() {
+				kept = true
+				break
 			}
 		}
+		if !kept {
+			metrics.DeleteCheck(target, check.ID())
+		}
 	}
 }
 
-func (p *HTTPProbe) runCheck(target *config.Target, check checker.Check) *proberesult.ProbeResult {
-	url := target.URL + check.Path
-	start := time.Now()
+// publishResult streams the result and any state transitions it causes to
+// event subscribers.
+func (p *HTTPProbe) publishResult(target *config.Target, check config.Check, result *proberesult.ProbeResult) {
+	transitions := p.states.record(target, check.ID(), result.Success, result.Message)
+	if p.events == nil {
+		return
+	}
+	p.events.Publish(events.TypeResult, target.Name, check.ID(), result)
+	for _, transition := range transitions {
+		p.events.Publish(events.TypeState, transition.Target, transition.Check, transition)
+	}
+}
 
-	client := httputils.NewHTTPClient()
+func (p *HTTPProbe) runCheck(target *config.Target, check config.Check) *proberesult.ProbeResult {
+	client := p.clients.Get(httputils.ResolveClientOptions(p.clientConfig, target.HTTPClient))
+	result := p.run(context.Background(), client, target.Auth, target.URL, check)
+	p.lastRunChecker.UpdateLastRun()
+	return result
+}
+
+// ProbeModule runs a module's check against url once and returns the
+// result. Unlike scheduled checks, the result isn't recorded or pushed.
+func (p *HTTPProbe) ProbeModule(ctx context.Context, url string, module config.Module) *proberesult.ProbeResult {
+	client := p.clients.Get(httputils.ResolveClientOptions(p.clientConfig, module.HTTPClient))
+	return p.run(ctx, client, module.Auth, url, module.Check)
+}
 
-	resp, err := client.Get(url)
+// run performs check against baseURL, either as a single request or as the
+// transaction of its steps, with the credentials of auth.
+func (p *HTTPProbe) run(ctx context.Context, client *http.Client, auth *config.AuthConfig, baseURL string, check config.Check) *proberesult.ProbeResult {
+	client = limitRedirects(client, check.FollowRedirects.Limit())
+	authenticate := func(req *http.Request) error {
+		return httputils.Authenticate(req, auth, p.tokens)
+	}
+
+	var result *proberesult.ProbeResult
+	if len(check.Steps) > 0 {
+		result = executeSteps(ctx, client, authenticate, baseURL, check)
+	} else {
+		result = execute(ctx, client, authenticate, baseURL+check.Path, checkconverter.ConvertConfigCheckToCheckerCheck(check))
+	}
+
+	// A rejected token may have been revoked before it expired
+	if result.StatusCode == http.StatusUnauthorized && auth != nil && auth.OAuth2 != nil {
+		p.tokens.Invalidate(*auth.OAuth2)
+	}
+	return result
+}
+
+func execute(ctx context.Context, client *http.Client, authenticate func(*http.Request) error, url string, check checker.Check) *proberesult.ProbeResult {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		result := proberesult.New(0)
+		result.SetMessage(fmt.Sprintf("Invalid request: %v", err))
+		return result
+	}
+	if err := authenticate(req); err != nil {
+		result := proberesult.New(0)
+		result.SetMessage(fmt.Sprintf("Authentication failed: %v", err))
+		return result
+	}
+
+	result, _, _ := perform(client, req, check)
+	return result
+}
+
+// limitRedirects returns a copy of client that follows at most max
+// redirects.
+func limitRedirects(client *http.Client, max int) *http.Client {
+	limited := *client
+	limited.CheckRedirect = redirectPolicy(max)
+	return &limited
+}
+
+// redirectPolicy stops following redirects after max of them. The redirect
+// response it stops at is returned, so that conditions decide whether a
+// longer chain is a failure.
+func redirectPolicy(max int) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
+
+// perform sends req and evaluates check against the response. The response,
+// whose body has been read and closed, and the body are returned as well
+// when the request completed.
+func perform(client *http.Client, req *http.Request, check checker.Check) (*proberesult.ProbeResult, *http.Response, []byte) {
+	// Record the redirects the client's policy lets it follow
+	var redirects []checker.Redirect
+	policy := client.CheckRedirect
+	if policy == nil {
+		policy = redirectPolicy(config.DefaultMaxRedirects)
+	}
+	recording := *client
+	recording.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if err := policy(next, via); err != nil {
+			return err
+		}
+		redirects = append(redirects, checker.Redirect{
+			URL:        next.Response.Request.URL.String(),
+			StatusCode: next.Response.StatusCode,
+			Location:   next.URL.String(),
+		})
+		return nil
+	}
+
+	start := time.Now()
+	resp, err := recording.Do(req)
 	duration := time.Since(start)
 
 	result := proberesult.New(duration.Seconds())
+	result.SetRedirects(redirects)
 
 	if err != nil {
 		result.SetMessage(fmt.Sprintf("HTTP request failed: %v", err))
-		return result
+		return result, nil, nil
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		result.SetMessage(fmt.Sprintf("Failed to read response body: %v", err))
-		return result
+		return result, nil, nil
 	}
 
 	result.SetStatusCode(resp.StatusCode)
@@ -263,13 +551,14 @@ func (p *HTTPProbe) runCheck(target *config.Target, check checker.Check) *prober
 		StatusCode: resp.StatusCode,
 		Body:       string(body),
 		Duration:   duration,
+		URL:        resp.Request.URL.String(),
+		Redirects:  redirects,
 	}
 
 	checkResult := checker.EvaluateCheck(check, checkerResponse)
 	result.SetSuccess(checkResult.Success)
 	result.SetMessage(checkResult.Message)
+	result.SetAssertions(checkResult.Assertions)
 
-	p.lastRunChecker.UpdateLastRun()
-
-	return result
+	return result, resp, body
 }
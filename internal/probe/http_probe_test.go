@@ -0,0 +1,373 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/events"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+	"github.com/c-j-p-nordquist/ekolod/pkg/metrics"
+	"github.com/c-j-p-nordquist/ekolod/pkg/proberesult"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMain(m *testing.M) {
+	// Results can't be pushed without a collector; keep the warnings quiet.
+	logging.InitLogger("error", "text")
+	os.Exit(m.Run())
+}
+
+// newTestProbe starts a probe for targets that publishes its results, and
+// shuts it down with the test.
+func newTestProbe(t *testing.T, targets ...*config.Target) *HTTPProbe {
+	t.Helper()
+	p := NewHTTPProbe(targets, &LastRunChecker{}, Options{
+		Events:    events.NewBroker(16),
+		Scheduler: config.SchedulerConfig{Workers: 4},
+	})
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := p.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	})
+	return p
+}
+
+// subscribe returns the result events of target, which must be received
+// promptly; the broker drops subscribers that fall behind.
+func subscribe(t *testing.T, p *HTTPProbe, target string) <-chan events.Event {
+	t.Helper()
+	sub, _ := p.events.Subscribe(events.Filter{Target: target}, 0)
+	t.Cleanup(sub.Close)
+
+	results := make(chan events.Event, 1024)
+	go func() {
+		defer close(results)
+		for event := range sub.C {
+			if event.Type == events.TypeResult {
+				results <- event
+			}
+		}
+	}()
+	return results
+}
+
+// awaitResult returns the next result event, failing the test if none
+// arrives in time.
+func awaitResult(t *testing.T, results <-chan events.Event) events.Event {
+	t.Helper()
+	select {
+	case event, ok := <-results:
+		if !ok {
+			t.Fatal("result subscription closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a result")
+	}
+	return events.Event{}
+}
+
+func testTarget(name, url string, paths ...string) *config.Target {
+	target := &config.Target{Name: name, URL: url, Frequency: 5 * time.Millisecond}
+	for _, path := range paths {
+		target.Checks = append(target.Checks, config.Check{Path: path})
+	}
+	return target
+}
+
+// eventually polls cond until it holds, failing the test after 5 seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTargetChangesWhileScheduling(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	p := newTestProbe(t, testTarget("stable", server.URL, "/a", "/b"))
+	results := subscribe(t, p, "stable")
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	loop := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				fn(i)
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	loop(func(i int) {
+		name := fmt.Sprintf("added-%d", i%4)
+		if i%2 == 0 {
+			p.AddTarget(testTarget(name, server.URL, "/"))
+		} else {
+			p.RemoveTarget(name)
+		}
+	})
+	loop(func(i int) {
+		p.UpdateTargetChecks("stable", []config.Check{{Path: "/a"}, {Path: fmt.Sprintf("/c%d", i%3)}})
+	})
+	loop(func(i int) {
+		target := testTarget("stable", server.URL, "/a", "/b")
+		target.Frequency = time.Duration(1+i%5) * time.Millisecond
+		p.UpdateTarget(target)
+	})
+	loop(func(int) {
+		p.GetMetrics()
+		p.GetTargets()
+	})
+
+	// Keep changing targets until checks of the stable target completed
+	// through the churn
+	for i := 0; i < 20; i++ {
+		awaitResult(t, results)
+	}
+	close(stop)
+	wg.Wait()
+
+	for {
+		if event := awaitResult(t, results); event.Check == "/a" {
+			break
+		}
+	}
+	if p.GetMetrics()["stable"]["/a"] == nil {
+		t.Error("no result recorded for the stable target")
+	}
+}
+
+func TestRemovedTargetResultsAreDropped(t *testing.T) {
+	inFlight := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case inFlight <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p := newTestProbe(t, testTarget("removed", server.URL, "/"))
+	results := subscribe(t, p, "removed")
+
+	// Remove the target while its check waits for a response, then wait
+	// for the check to finish
+	<-inFlight
+	p.RemoveTarget("removed")
+	release <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if _, exists := p.GetMetrics()["removed"]; exists {
+		t.Error("result of a removed target was recorded")
+	}
+	select {
+	case event := <-results:
+		t.Errorf("result of a removed target was published: %+v", event)
+	default:
+	}
+	if targets := p.GetTargets(); len(targets) != 0 {
+		t.Errorf("targets = %v, want none", targets)
+	}
+}
+
+func TestMaxConcurrencyHoldsAcrossReload(t *testing.T) {
+	var running, peak atomic.Int64
+	started := make(chan struct{}, 100)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		started <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p := newTestProbe(t, testTarget("slow", server.URL, "/"))
+	results := subscribe(t, p, "slow")
+	skipped := func() float64 {
+		var m dto.Metric
+		metrics.SchedulerSkippedRuns.WithLabelValues("slow", "/").Write(&m)
+		return m.GetCounter().GetValue()
+	}
+
+	// Replace the job while a run of the previous one is in flight, and
+	// wait for the new job to come due and be skipped
+	for i := 0; i < 3; i++ {
+		<-started
+		before := skipped()
+		target := testTarget("slow", server.URL, "/")
+		target.Frequency = time.Duration(1+i) * time.Millisecond
+		p.UpdateTarget(target)
+		eventually(t, "a skipped run", func() bool { return skipped() > before })
+
+		release <- struct{}{}
+		awaitResult(t, results)
+	}
+
+	if got := peak.Load(); got != 1 {
+		t.Errorf("peak concurrent runs = %d, want 1", got)
+	}
+}
+
+func TestUpdateTargetKeepsUnchangedChecks(t *testing.T) {
+	definition := func() *config.Target {
+		target := testTarget("api", "http://127.0.0.1:1", "/a", "/b")
+		target.Frequency = time.Hour
+		return target
+	}
+	target := definition()
+	p := newTestProbe(t, target)
+
+	p.states.record(target, "/a", true, "")
+	p.states.record(target, "/b", true, "")
+	next := func(check string) time.Time {
+		p.scheduler.mu.Lock()
+		defer p.scheduler.mu.Unlock()
+		for _, j := range p.scheduler.jobs["api"] {
+			if j.check.ID() == check {
+				return j.next
+			}
+		}
+		return time.Time{}
+	}
+	scheduled := next("/a")
+
+	// Reloading an identical definition changes nothing
+	p.UpdateTarget(definition())
+	p.mu.RLock()
+	running := p.targets[0]
+	p.mu.RUnlock()
+	if running != target {
+		t.Error("identical definition replaced the running target")
+	}
+	if got := next("/a"); !got.Equal(scheduled) {
+		t.Errorf("identical definition rescheduled /a from %v to %v", scheduled, got)
+	}
+
+	// Changing one check resets only its state
+	changed := definition()
+	changed.Checks[1].MaxConcurrency = 2
+	p.UpdateTarget(changed)
+	p.states.mu.Lock()
+	_, keptA := p.states.checks["api"]["/a"]
+	_, keptB := p.states.checks["api"]["/b"]
+	p.states.mu.Unlock()
+	if !keptA || keptB {
+		t.Errorf("state kept for /a = %v, /b = %v, want only /a", keptA, keptB)
+	}
+	if got := next("/a"); !got.Equal(scheduled) {
+		t.Errorf("changing /b rescheduled /a from %v to %v", scheduled, got)
+	}
+
+	// A result of the unchanged check that was in flight still counts
+	if !p.record(target, target.Checks[0], &proberesult.ProbeResult{Success: true}) {
+		t.Error("result of an unchanged check was dropped")
+	}
+	if p.record(target, target.Checks[1], &proberesult.ProbeResult{Success: true}) {
+		t.Error("result of a changed check was recorded")
+	}
+}
+
+func TestAddTargetRejectsDuplicateName(t *testing.T) {
+	p := newTestProbe(t)
+	target := testTarget("api", "http://127.0.0.1:1", "/")
+	target.Frequency = time.Hour
+	if err := p.AddTarget(target); err != nil {
+		t.Fatalf("AddTarget: %v", err)
+	}
+
+	duplicate := testTarget("api", "http://127.0.0.1:2", "/")
+	if err := p.AddTarget(duplicate); !errors.Is(err, ErrTargetExists) {
+		t.Errorf("AddTarget of a duplicate = %v, want ErrTargetExists", err)
+	}
+	if targets := p.GetTargets(); len(targets) != 1 || targets[0].URL != target.URL {
+		t.Errorf("targets = %+v, want only the first", targets)
+	}
+	p.scheduler.mu.Lock()
+	jobs := len(p.scheduler.queue)
+	p.scheduler.mu.Unlock()
+	if jobs != 1 {
+		t.Errorf("%d jobs scheduled, want 1", jobs)
+	}
+}
+
+func TestRunProbeRespectsMaxConcurrency(t *testing.T) {
+	var requests atomic.Int64
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	target := testTarget("api", server.URL, "/slow", "/fast")
+	target.Frequency = time.Hour
+	p := newTestProbe(t, target)
+	results := subscribe(t, p, "api")
+
+	// Run every check while /slow is still in flight from the first run
+	go p.RunProbe()
+	<-started
+	if event := awaitResult(t, results); event.Check != "/fast" {
+		t.Fatalf("result of %s, want /fast", event.Check)
+	}
+	before := requests.Load()
+	p.RunProbe()
+
+	if got := requests.Load() - before; got != 1 {
+		t.Errorf("second run sent %d requests, want 1 for /fast", got)
+	}
+	if event := awaitResult(t, results); event.Check != "/fast" {
+		t.Errorf("result of %s published while it was still running", event.Check)
+	}
+	release <- struct{}{}
+	if event := awaitResult(t, results); event.Check != "/slow" {
+		t.Errorf("result of %s, want /slow", event.Check)
+	}
+}
@@ -1,6 +1,8 @@
 package probe
 
 import (
+	"context"
+
 	"github.com/c-j-p-nordquist/ekolod/pkg/config"
 	"github.com/c-j-p-nordquist/ekolod/pkg/proberesult"
 )
@@ -8,11 +10,14 @@ import (
 type Probe interface {
 	Start()
 	Stop()
+	Shutdown(ctx context.Context) error
 	UpdateTargets(targets []*config.Target)
 	GetTargets() []config.Target
 	GetMetrics() map[string]map[string]*proberesult.ProbeResult
-	AddTarget(target *config.Target)
+	AddTarget(target *config.Target) error
 	RemoveTarget(name string)
 	RunProbe()
 	UpdateTargetChecks(name string, checks []config.Check)
+	UpdateTarget(target *config.Target)
+	ProbeModule(ctx context.Context, url string, module config.Module) *proberesult.ProbeResult
 }
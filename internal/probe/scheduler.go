@@ -0,0 +1,412 @@
+package probe
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/metrics"
+)
+
+const (
+	defaultWorkers   = 8
+	defaultFrequency = 30 * time.Second
+)
+
+type job struct {
+	target *config.Target
+	check  config.Check
+
+	// base advances by exactly one frequency per run; next adds the job's
+	// fixed jitter offset so runs stay spread out without drifting.
+	base   time.Time
+	next   time.Time
+	offset time.Duration
+
+	// runs is shared with the job that replaces this one on reload, so
+	// max_concurrency holds while runs of the old job are still in flight.
+	runs  *runs
+	index int
+}
+
+// runs counts the in-flight runs of a check.
+type runs struct {
+	running int
+}
+
+type jobHeap []*job
+
+func (h jobHeap) Len() int           { return len(h) }
+func (h jobHeap) Less(i, j int) bool { return h[i].next.Before(h[j].next) }
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	j := x.(*job)
+	j.index = len(*h)
+	*h = append(*h, j)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	j := old[n-1]
+	old[n-1] = nil
+	j.index = -1
+	*h = old[:n-1]
+	return j
+}
+
+type dispatch struct {
+	job       *job
+	scheduled time.Time
+	// done is called when a run requested by runNow finished or was
+	// skipped.
+	done func()
+}
+
+// scheduler keeps every check in a heap ordered by next run time and hands
+// due checks to a fixed pool of workers.
+type scheduler struct {
+	mu      sync.Mutex
+	queue   jobHeap
+	jobs    map[string][]*job
+	workers int
+	jitter  float64
+	run     func(*job)
+	rand    *rand.Rand
+	// immediate holds the runs requested by runNow, which are dispatched
+	// ahead of the schedule.
+	immediate []dispatch
+
+	wake    chan struct{}
+	stop    chan struct{}
+	work    chan dispatch
+	wg      sync.WaitGroup
+	running bool
+}
+
+func newScheduler(cfg config.SchedulerConfig, run func(*job)) *scheduler {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	jitter := cfg.Jitter
+	if jitter < 0 {
+		jitter = 0
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	metrics.SchedulerWorkers.Set(float64(workers))
+	return &scheduler{
+		jobs:    make(map[string][]*job),
+		workers: workers,
+		jitter:  jitter,
+		run:     run,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+func (s *scheduler) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return
+	}
+	s.running = true
+	s.stop = make(chan struct{})
+	s.work = make(chan dispatch)
+
+	s.wg.Add(s.workers + 1)
+	for i := 0; i < s.workers; i++ {
+		go s.worker(s.work)
+	}
+	go s.loop(s.stop, s.work)
+}
+
+// shutdown stops dispatching and waits for in-flight checks to finish.
+func (s *scheduler) shutdown() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	close(s.stop)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *scheduler) add(target *config.Target) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Checks keep their in-flight count across a reload, and their place in
+	// the schedule unless the frequency changed
+	previous := make(map[string]*job)
+	for _, j := range s.jobs[target.Name] {
+		previous[j.check.ID()] = j
+	}
+	s.removeLocked(target.Name)
+
+	frequency := frequencyOf(target)
+	now := time.Now()
+	jobs := make([]*job, 0, len(target.Checks))
+	for _, check := range target.Checks {
+		j := &job{
+			target: target,
+			check:  check,
+			base:   now.Add(frequency),
+			runs:   &runs{},
+		}
+		prev, exists := previous[check.ID()]
+		if exists {
+			j.runs = prev.runs
+		}
+		switch {
+		case exists && frequencyOf(prev.target) == frequency:
+			j.base, j.offset = prev.base, prev.offset
+		case s.jitter > 0:
+			j.offset = time.Duration(s.rand.Float64() * s.jitter * float64(frequency))
+		}
+		j.next = j.base.Add(j.offset)
+		heap.Push(&s.queue, j)
+		jobs = append(jobs, j)
+	}
+	s.jobs[target.Name] = jobs
+	s.signal()
+}
+
+// runNow runs every check once, ahead of its schedule and without moving
+// it, and waits for the runs to finish. The runs go through the workers and
+// count toward max_concurrency like scheduled ones.
+func (s *scheduler) runNow() {
+	var wg sync.WaitGroup
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	for _, jobs := range s.jobs {
+		for _, j := range jobs {
+			wg.Add(1)
+			s.immediate = append(s.immediate, dispatch{job: j, scheduled: now, done: wg.Done})
+		}
+	}
+	s.signal()
+	s.mu.Unlock()
+
+	wg.Wait()
+}
+
+func (s *scheduler) remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(name)
+	s.signal()
+}
+
+func (s *scheduler) removeLocked(name string) {
+	for _, j := range s.jobs[name] {
+		if j.index >= 0 {
+			heap.Remove(&s.queue, j.index)
+		}
+	}
+	delete(s.jobs, name)
+}
+
+func (s *scheduler) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.jobs {
+		s.removeLocked(name)
+	}
+}
+
+// longestFrequency returns the longest frequency of the scheduled checks,
+// or 0 if there are none.
+func (s *scheduler) longestFrequency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var longest time.Duration
+	for _, j := range s.queue {
+		if f := frequencyOf(j.target); f > longest {
+			longest = f
+		}
+	}
+	return longest
+}
+
+// overdue returns how long the next check is past its run time. It grows
+// while the loop can't hand checks to the workers.
+func (s *scheduler) overdue(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running || len(s.queue) == 0 {
+		return 0
+	}
+	if late := now.Sub(s.queue[0].next); late > 0 {
+		return late
+	}
+	return 0
+}
+
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) loop(stop chan struct{}, work chan dispatch) {
+	defer s.wg.Done()
+	defer close(work)
+	defer s.dropImmediate()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		if len(s.immediate) > 0 {
+			d := s.immediate[0]
+			s.immediate = s.immediate[1:]
+			if !s.acquireLocked(d.job) {
+				s.mu.Unlock()
+				d.done()
+				continue
+			}
+			s.mu.Unlock()
+
+			select {
+			case work <- d:
+			case <-stop:
+				s.finish(d.job)
+				d.done()
+				return
+			}
+			continue
+		}
+
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-stop:
+				return
+			case <-s.wake:
+			}
+			continue
+		}
+
+		j := s.queue[0]
+		now := time.Now()
+		if wait := j.next.Sub(now); wait > 0 {
+			s.mu.Unlock()
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			select {
+			case <-stop:
+				return
+			case <-s.wake:
+			case <-timer.C:
+			}
+			continue
+		}
+
+		scheduled := j.next
+		s.reschedule(j, now)
+
+		if !s.acquireLocked(j) {
+			s.mu.Unlock()
+			continue
+		}
+		s.mu.Unlock()
+
+		select {
+		case work <- dispatch{job: j, scheduled: scheduled}:
+		case <-stop:
+			s.finish(j)
+			return
+		}
+	}
+}
+
+// acquireLocked counts a run of j about to be dispatched. It returns false,
+// counting a skipped run instead, if the check already runs max_concurrency
+// times.
+func (s *scheduler) acquireLocked(j *job) bool {
+	maxConcurrency := j.check.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+	if j.runs.running >= maxConcurrency {
+		metrics.SchedulerSkippedRuns.WithLabelValues(j.target.Name, j.check.ID()).Inc()
+		return false
+	}
+	j.runs.running++
+	return true
+}
+
+// dropImmediate releases the callers of runNow waiting for runs that won't
+// be dispatched because the scheduler stopped.
+func (s *scheduler) dropImmediate() {
+	s.mu.Lock()
+	pending := s.immediate
+	s.immediate = nil
+	s.mu.Unlock()
+	for _, d := range pending {
+		d.done()
+	}
+}
+
+func (s *scheduler) reschedule(j *job, now time.Time) {
+	frequency := frequencyOf(j.target)
+	j.base = j.base.Add(frequency)
+	// Don't replay missed runs in a burst after a stall.
+	if j.base.Before(now) {
+		j.base = now.Add(frequency)
+	}
+	j.next = j.base.Add(j.offset)
+	heap.Fix(&s.queue, j.index)
+}
+
+func frequencyOf(target *config.Target) time.Duration {
+	if target.Frequency <= 0 {
+		return defaultFrequency
+	}
+	return target.Frequency
+}
+
+func (s *scheduler) worker(work chan dispatch) {
+	defer s.wg.Done()
+	for d := range work {
+		metrics.SchedulerLag.Observe(time.Since(d.scheduled).Seconds())
+		metrics.SchedulerInFlight.Inc()
+		s.run(d.job)
+		metrics.SchedulerInFlight.Dec()
+		s.finish(d.job)
+		if d.done != nil {
+			d.done()
+		}
+	}
+}
+
+func (s *scheduler) finish(j *job) {
+	s.mu.Lock()
+	j.runs.running--
+	s.mu.Unlock()
+}
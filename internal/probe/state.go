@@ -0,0 +1,146 @@
+package probe
+
+import (
+	"sync"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/metrics"
+)
+
+type State string
+
+const (
+	StateUnknown State = "unknown"
+	StateUp      State = "up"
+	StateDown    State = "down"
+)
+
+type StateTransition struct {
+	Target  string    `json:"target"`
+	Check   string    `json:"check,omitempty"`
+	From    State     `json:"from"`
+	To      State     `json:"to"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+type checkState struct {
+	state                State
+	consecutiveFailures  int
+	consecutiveSuccesses int
+}
+
+// stateTracker applies a target's failure_tolerance and recovery_threshold to
+// consecutive check results and reports when a check or target changes state.
+type stateTracker struct {
+	mu      sync.Mutex
+	checks  map[string]map[string]*checkState
+	targets map[string]State
+}
+
+func newStateTracker() *stateTracker {
+	return &stateTracker{
+		checks:  make(map[string]map[string]*checkState),
+		targets: make(map[string]State),
+	}
+}
+
+func (t *stateTracker) record(target *config.Target, check string, success bool, message string) []StateTransition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.checks[target.Name] == nil {
+		t.checks[target.Name] = make(map[string]*checkState)
+	}
+	cs, exists := t.checks[target.Name][check]
+	if !exists {
+		cs = &checkState{state: StateUnknown}
+		t.checks[target.Name][check] = cs
+	}
+
+	failureTolerance := target.FailureTolerance
+	if failureTolerance <= 0 {
+		failureTolerance = 1
+	}
+	recoveryThreshold := target.RecoveryThreshold
+	if recoveryThreshold <= 0 {
+		recoveryThreshold = 1
+	}
+
+	previous := cs.state
+	if success {
+		cs.consecutiveSuccesses++
+		cs.consecutiveFailures = 0
+		// The first result decides the initial state without waiting for the threshold.
+		if cs.state == StateUnknown || cs.consecutiveSuccesses >= recoveryThreshold {
+			cs.state = StateUp
+		}
+	} else {
+		cs.consecutiveFailures++
+		cs.consecutiveSuccesses = 0
+		if cs.state == StateUnknown || cs.consecutiveFailures >= failureTolerance {
+			cs.state = StateDown
+		}
+	}
+
+	metrics.CheckConsecutiveFailures.WithLabelValues(target.Name, check).Set(float64(cs.consecutiveFailures))
+
+	now := time.Now().UTC()
+	var transitions []StateTransition
+	if cs.state != previous {
+		transitions = append(transitions, StateTransition{
+			Target:  target.Name,
+			Check:   check,
+			From:    previous,
+			To:      cs.state,
+			Message: message,
+			Time:    now,
+		})
+	}
+
+	targetState := StateUp
+	for _, s := range t.checks[target.Name] {
+		if s.state == StateDown {
+			targetState = StateDown
+			break
+		}
+	}
+	previousTarget, exists := t.targets[target.Name]
+	if !exists {
+		previousTarget = StateUnknown
+	}
+	if targetState != previousTarget {
+		t.targets[target.Name] = targetState
+		metrics.SetTargetState(target.Name, string(targetState))
+		transitions = append(transitions, StateTransition{
+			Target:  target.Name,
+			From:    previousTarget,
+			To:      targetState,
+			Message: message,
+			Time:    now,
+		})
+	}
+
+	return transitions
+}
+
+// removeChecks resets the state of the given checks of target.
+func (t *stateTracker) removeChecks(target string, checks []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, check := range checks {
+		delete(t.checks[target], check)
+	}
+	if len(t.checks[target]) == 0 {
+		delete(t.checks, target)
+		delete(t.targets, target)
+	}
+}
+
+func (t *stateTracker) remove(target string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.checks, target)
+	delete(t.targets, target)
+}
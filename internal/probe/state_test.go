@@ -0,0 +1,38 @@
+package probe
+
+import (
+	"testing"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+)
+
+func TestChecksSharingPathKeepSeparateState(t *testing.T) {
+	target := &config.Target{
+		Name:             "api",
+		FailureTolerance: 2,
+		Checks: []config.Check{
+			{Name: "status", Path: "/"},
+			{Name: "response-time", Path: "/"},
+		},
+	}
+	status, latency := target.Checks[0].ID(), target.Checks[1].ID()
+	tracker := newStateTracker()
+
+	// The failing check reaches its tolerance although the other check
+	// of the same path passes in between.
+	tracker.record(target, status, true, "")
+	tracker.record(target, latency, true, "")
+	tracker.record(target, status, false, "timeout")
+	tracker.record(target, latency, true, "")
+	transitions := tracker.record(target, status, false, "timeout")
+
+	if len(transitions) != 2 {
+		t.Fatalf("transitions = %+v, want the check and the target going down", transitions)
+	}
+	if got := transitions[0]; got.Check != "status" || got.To != StateDown {
+		t.Errorf("check transition = %+v, want status down", got)
+	}
+	if got := transitions[1]; got.Check != "" || got.To != StateDown {
+		t.Errorf("target transition = %+v, want target down", got)
+	}
+}
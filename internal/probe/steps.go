@@ -0,0 +1,283 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/checkconverter"
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/proberesult"
+	"github.com/c-j-p-nordquist/ekolod/pkg/secrets"
+)
+
+// executeSteps runs the steps of check in order against baseURL. Cookies
+// set by a response are sent with the following steps, and variables
+// captured by a step can be used in the templates of the following steps.
+// The transaction stops at the first failing step. Every request is passed
+// to authenticate before it is sent.
+func executeSteps(ctx context.Context, client *http.Client, authenticate func(*http.Request) error, baseURL string, check config.Check) *proberesult.ProbeResult {
+	start := time.Now()
+
+	// The client is shared with other checks, so give this run its own jar.
+	jar, _ := cookiejar.New(nil)
+	stepClient := *client
+	stepClient.Jar = jar
+
+	vars := make(map[string]string)
+	var (
+		steps []proberesult.StepResult
+		last  *proberesult.ProbeResult
+		first *proberesult.ProbeResult
+	)
+	failure := ""
+	for i, step := range check.Steps {
+		name := fmt.Sprintf("Step %d", i+1)
+		if step.Name != "" {
+			name += fmt.Sprintf(" (%s)", step.Name)
+		}
+
+		stepResult, result := executeStep(ctx, &stepClient, authenticate, baseURL, step, check.EvaluateAll, vars)
+		redactStep(&stepResult, vars)
+		steps = append(steps, stepResult)
+		if result != nil {
+			last = result
+			if first == nil {
+				first = result
+			}
+		}
+		if !stepResult.Success {
+			failure = fmt.Sprintf("%s: %s", name, stepResult.Message)
+			break
+		}
+	}
+
+	result := proberesult.New(time.Since(start).Seconds())
+	result.Steps = steps
+	if last != nil {
+		result.SetStatusCode(last.StatusCode)
+		result.SetContentLength(last.ContentLength)
+	}
+	if first != nil && first.TLSVersion != "" {
+		result.SetTLSVersion(first.TLSVersion)
+		result.SetCertExpiryDays(first.CertExpiryDays)
+	}
+	if failure != "" {
+		result.SetMessage(failure)
+		return result
+	}
+	result.SetSuccess(true)
+	result.SetMessage(fmt.Sprintf("All %d steps passed", len(steps)))
+	return result
+}
+
+// redactStep removes secrets from what a step reports. Captured values,
+// such as session tokens, are treated as secrets too, but only within the
+// transaction that captured them.
+func redactStep(step *proberesult.StepResult, vars map[string]string) {
+	captured := make([]string, 0, len(vars))
+	for _, value := range vars {
+		captured = append(captured, value)
+	}
+	redact := func(s string) string { return secrets.RedactWith(s, captured...) }
+
+	step.URL = redact(step.URL)
+	step.Message = redact(step.Message)
+	for i := range step.Redirects {
+		step.Redirects[i].URL = redact(step.Redirects[i].URL)
+		step.Redirects[i].Location = redact(step.Redirects[i].Location)
+	}
+	for i := range step.Assertions {
+		step.Assertions[i].Actual = redact(step.Assertions[i].Actual)
+		step.Assertions[i].Message = redact(step.Assertions[i].Message)
+	}
+}
+
+// executeStep renders and sends one step's request, evaluates its
+// conditions and, if they pass, adds its captures to vars. The probe result
+// of the request is nil if the request couldn't be built.
+func executeStep(ctx context.Context, client *http.Client, authenticate func(*http.Request) error, baseURL string, step config.Step, evaluateAll bool, vars map[string]string) (proberesult.StepResult, *proberesult.ProbeResult) {
+	method := strings.ToUpper(step.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	stepResult := proberesult.StepResult{Name: step.Name, Method: method}
+
+	path, err := render("path", step.Path, vars)
+	if err != nil {
+		stepResult.Message = err.Error()
+		return stepResult, nil
+	}
+	stepResult.URL = baseURL + path
+
+	var body io.Reader
+	if step.Body != "" {
+		rendered, err := render("body", step.Body, vars)
+		if err != nil {
+			stepResult.Message = err.Error()
+			return stepResult, nil
+		}
+		body = strings.NewReader(rendered)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, stepResult.URL, body)
+	if err != nil {
+		stepResult.Message = fmt.Sprintf("Invalid request: %v", err)
+		return stepResult, nil
+	}
+	if err := authenticate(req); err != nil {
+		stepResult.Message = fmt.Sprintf("Authentication failed: %v", err)
+		return stepResult, nil
+	}
+	for header, value := range step.Headers {
+		// Secrets read from the environment or files aren't templates
+		var rendered string
+		if value.IsReference() {
+			rendered, err = value.Value()
+			if err != nil {
+				err = fmt.Errorf("Failed to read header %s: %v", header, err)
+			}
+		} else {
+			rendered, err = render("header "+header, string(value), vars)
+		}
+		if err != nil {
+			stepResult.Message = err.Error()
+			return stepResult, nil
+		}
+		if strings.EqualFold(header, "Host") {
+			req.Host = rendered
+			continue
+		}
+		req.Header.Set(header, rendered)
+	}
+
+	if step.FollowRedirects != nil {
+		client = limitRedirects(client, step.FollowRedirects.Limit())
+	}
+	result, resp, respBody := perform(client, req, checkconverter.ConvertStep(step, evaluateAll))
+	stepResult.Redirects = result.Redirects
+	stepResult.StatusCode = result.StatusCode
+	stepResult.Duration = result.Duration
+	stepResult.Success = result.Success
+	stepResult.Message = result.Message
+	stepResult.Assertions = result.Assertions
+	if !result.Success {
+		return stepResult, result
+	}
+
+	captured, err := capture(step.Capture, resp, respBody)
+	if err != nil {
+		stepResult.Success = false
+		stepResult.Message = err.Error()
+		return stepResult, result
+	}
+	for name, value := range captured {
+		vars[name] = value
+		stepResult.Captured = append(stepResult.Captured, name)
+	}
+	sort.Strings(stepResult.Captured)
+	return stepResult, result
+}
+
+// render executes text as a template over vars. Referring to a variable
+// that wasn't captured is an error.
+func render(field, text string, vars map[string]string) (string, error) {
+	tmpl, err := template.New(field).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("Invalid %s template: %v", field, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("Failed to render %s: %v", field, err)
+	}
+	return b.String(), nil
+}
+
+// capture extracts the variables of captures from a response. Every
+// capture must find a value.
+func capture(captures map[string]config.Capture, resp *http.Response, body []byte) (map[string]string, error) {
+	values := make(map[string]string, len(captures))
+	var document interface{}
+	for name, c := range captures {
+		switch {
+		case c.JSON != "":
+			if document == nil {
+				if err := json.Unmarshal(body, &document); err != nil {
+					return nil, fmt.Errorf("Failed to capture %s: response is not JSON: %v", name, err)
+				}
+			}
+			value, err := lookupJSON(document, c.JSON)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to capture %s: %v", name, err)
+			}
+			values[name] = value
+		case c.Header != "":
+			value := resp.Header.Get(c.Header)
+			if value == "" {
+				return nil, fmt.Errorf("Failed to capture %s: header %s is missing", name, c.Header)
+			}
+			values[name] = value
+		case c.Regex != "":
+			re, err := regexp.Compile(c.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to capture %s: %v", name, err)
+			}
+			match := re.FindSubmatch(body)
+			if match == nil {
+				return nil, fmt.Errorf("Failed to capture %s: no match for %s", name, c.Regex)
+			}
+			if len(match) > 1 {
+				values[name] = string(match[1])
+			} else {
+				values[name] = string(match[0])
+			}
+		}
+	}
+	return values, nil
+}
+
+// lookupJSON follows a dot-separated path of object keys and array indexes
+// through document. Strings are returned as is, other values as JSON.
+func lookupJSON(document interface{}, path string) (string, error) {
+	value := document
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return "", fmt.Errorf("%s not found", path)
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("%s not found", path)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("%s not found", path)
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	if value == nil {
+		return "", fmt.Errorf("%s is null", path)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
@@ -1,26 +1,176 @@
 package checkconverter
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
+	"text/template"
+
 	"github.com/c-j-p-nordquist/ekolod/pkg/checker"
 	"github.com/c-j-p-nordquist/ekolod/pkg/config"
 )
 
 func ConvertConfigCheckToCheckerCheck(configCheck config.Check) checker.Check {
 	return checker.Check{
-		Path:         configCheck.Path,
-		HTTPStatus:   convertCondition(configCheck.HTTPStatus),
-		ResponseTime: convertCondition(configCheck.ResponseTime),
-		ResponseBody: convertCondition(configCheck.ResponseBody),
+		Path:                configCheck.Path,
+		HTTPStatus:          convertCondition(configCheck.HTTPStatus),
+		ResponseTime:        convertCondition(configCheck.ResponseTime),
+		ResponseBody:        convertCondition(configCheck.ResponseBody),
+		RedirectCount:       convertCondition(configCheck.RedirectCount),
+		FirstRedirectStatus: convertCondition(configCheck.FirstRedirectStatus),
+		FinalURL:            convertCondition(configCheck.FinalURL),
+		HTTPSUpgrade:        convertCondition(configCheck.HTTPSUpgrade),
+		Assert:              convertAssertion(configCheck.Assert),
+		EvaluateAll:         configCheck.EvaluateAll,
+	}
+}
+
+func convertAssertion(configAssertion *config.Assertion) *checker.Assertion {
+	if configAssertion == nil {
+		return nil
+	}
+	return &checker.Assertion{
+		AllOf:               convertAssertions(configAssertion.AllOf),
+		AnyOf:               convertAssertions(configAssertion.AnyOf),
+		Not:                 convertAssertion(configAssertion.Not),
+		HTTPStatus:          convertCondition(configAssertion.HTTPStatus),
+		ResponseTime:        convertCondition(configAssertion.ResponseTime),
+		ResponseBody:        convertCondition(configAssertion.ResponseBody),
+		RedirectCount:       convertCondition(configAssertion.RedirectCount),
+		FirstRedirectStatus: convertCondition(configAssertion.FirstRedirectStatus),
+		FinalURL:            convertCondition(configAssertion.FinalURL),
+		HTTPSUpgrade:        convertCondition(configAssertion.HTTPSUpgrade),
 	}
 }
 
+func convertAssertions(configAssertions []config.Assertion) []checker.Assertion {
+	if configAssertions == nil {
+		return nil
+	}
+	assertions := make([]checker.Assertion, len(configAssertions))
+	for i := range configAssertions {
+		assertions[i] = *convertAssertion(&configAssertions[i])
+	}
+	return assertions
+}
+
 func convertCondition(configCondition *config.Condition) *checker.Condition {
 	if configCondition == nil {
 		return nil
 	}
 	return &checker.Condition{
-		Type:   configCondition.Type,
-		Value:  configCondition.Value,
-		Values: configCondition.Values,
+		Type:       configCondition.Type,
+		Value:      configCondition.Value,
+		Values:     configCondition.Values,
+		IgnoreCase: configCondition.IgnoreCase,
+	}
+}
+
+// ConvertStep converts the conditions of a step of a multi-step check.
+func ConvertStep(step config.Step, evaluateAll bool) checker.Check {
+	return checker.Check{
+		Path:                step.Path,
+		HTTPStatus:          convertCondition(step.HTTPStatus),
+		ResponseTime:        convertCondition(step.ResponseTime),
+		ResponseBody:        convertCondition(step.ResponseBody),
+		RedirectCount:       convertCondition(step.RedirectCount),
+		FirstRedirectStatus: convertCondition(step.FirstRedirectStatus),
+		FinalURL:            convertCondition(step.FinalURL),
+		HTTPSUpgrade:        convertCondition(step.HTTPSUpgrade),
+		Assert:              convertAssertion(step.Assert),
+		EvaluateAll:         evaluateAll,
+	}
+}
+
+// ValidateCheck reports conditions, templates and captures of the check
+// that can never be evaluated.
+func ValidateCheck(check config.Check) error {
+	if len(check.Steps) == 0 {
+		return checker.Validate(ConvertConfigCheckToCheckerCheck(check))
+	}
+
+	var errs []error
+	if check.HTTPStatus != nil || check.ResponseTime != nil || check.ResponseBody != nil || check.Assert != nil ||
+		check.RedirectCount != nil || check.FirstRedirectStatus != nil || check.FinalURL != nil || check.HTTPSUpgrade != nil {
+		errs = append(errs, errors.New("conditions of a multi-step check belong in its steps"))
+	}
+	for i, step := range check.Steps {
+		name := fmt.Sprintf("step %d", i+1)
+		if step.Name != "" {
+			name += fmt.Sprintf(" (%s)", step.Name)
+		}
+		if err := checker.Validate(ConvertStep(step, false)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		templates := map[string]string{"path": step.Path, "body": step.Body}
+		for header, value := range step.Headers {
+			if !value.IsReference() {
+				templates["header "+header] = string(value)
+			}
+		}
+		for field, text := range templates {
+			if _, err := template.New(field).Parse(text); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", name, field, err))
+			}
+		}
+		for variable, capture := range step.Capture {
+			sources := 0
+			for _, source := range []string{capture.JSON, capture.Header, capture.Regex} {
+				if source != "" {
+					sources++
+				}
+			}
+			if sources != 1 {
+				errs = append(errs, fmt.Errorf("%s: capture %q needs exactly one of json, header or regex", name, variable))
+			}
+			if capture.Regex != "" {
+				if _, err := regexp.Compile(capture.Regex); err != nil {
+					errs = append(errs, fmt.Errorf("%s: capture %q: %w", name, variable, err))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateTarget reports checks of the target that can never be evaluated
+// and an incomplete auth configuration.
+func ValidateTarget(target config.Target) error {
+	var errs []error
+	if err := target.Auth.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("target %q, auth: %w", target.Name, err))
+	}
+	// Results and state are kept per check, so checks sharing a path need
+	// distinct names
+	seen := make(map[string]int)
+	for i, check := range target.Checks {
+		if err := ValidateCheck(check); err != nil {
+			errs = append(errs, fmt.Errorf("target %q, check %d (%s): %w", target.Name, i, check.ID(), err))
+		}
+		if first, exists := seen[check.ID()]; exists {
+			errs = append(errs, fmt.Errorf("target %q, checks %d and %d are both named %q, set a distinct name", target.Name, first, i, check.ID()))
+			continue
+		}
+		seen[check.ID()] = i
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks every target and module of cfg.
+func Validate(cfg *config.Config) error {
+	var errs []error
+	for _, target := range cfg.Targets {
+		if err := ValidateTarget(target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for name, module := range cfg.Modules {
+		if err := module.Auth.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("module %q, auth: %w", name, err))
+		}
+		if err := ValidateCheck(module.Check); err != nil {
+			errs = append(errs, fmt.Errorf("module %q: %w", name, err))
+		}
 	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,221 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fieldKind is the type of a response field, which decides how condition
+// values are interpreted.
+type fieldKind int
+
+const (
+	// Status codes are numbers, or classes such as 2xx.
+	statusField fieldKind = iota
+	// Durations are compared in seconds. Numbers are taken as seconds and
+	// strings are parsed as durations, e.g. 500ms.
+	durationField
+	// Strings are compared as is. Numbers and booleans are formatted.
+	stringField
+	// Numbers are plain counts.
+	numberField
+	// Booleans only support equality.
+	boolField
+)
+
+var conditionTypes = map[fieldKind][]string{
+	statusField:   {"eq", "in", "below", "above", "lte", "gte", "between"},
+	durationField: {"eq", "in", "below", "above", "lte", "gte", "between"},
+	stringField:   {"eq", "in", "contains", "starts_with", "ends_with", "regex"},
+	numberField:   {"eq", "in", "below", "above", "lte", "gte", "between"},
+	boolField:     {"eq"},
+}
+
+// coerceCondition checks that condition suits a field of the given kind and
+// converts its values to float64 for numeric fields or string for string
+// fields, so that comparisons don't depend on how the values were decoded.
+func coerceCondition(kind fieldKind, condition Condition) (Condition, error) {
+	conditionType := condition.Type
+	if base, ok := strings.CutPrefix(conditionType, "not_"); ok && negatable[base] {
+		conditionType = base
+	}
+	supported := false
+	for _, t := range conditionTypes[kind] {
+		if t == conditionType {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return condition, fmt.Errorf("condition %q is not supported here", condition.Type)
+	}
+
+	coerced := condition
+	switch conditionType {
+	case "in", "between":
+		if len(condition.Values) == 0 {
+			return condition, fmt.Errorf("condition %q requires values", condition.Type)
+		}
+		if conditionType == "between" && len(condition.Values) != 2 {
+			return condition, fmt.Errorf("condition %q requires two values, got %d", condition.Type, len(condition.Values))
+		}
+		coerced.Values = make([]interface{}, len(condition.Values))
+		for i, v := range condition.Values {
+			value, err := coerceValue(kind, v, conditionType == "in")
+			if err != nil {
+				return condition, fmt.Errorf("condition %q: %w", condition.Type, err)
+			}
+			coerced.Values[i] = value
+		}
+		if conditionType == "between" && coerced.Values[0].(float64) > coerced.Values[1].(float64) {
+			return condition, fmt.Errorf("condition %q: lower bound %v is above upper bound %v", condition.Type, condition.Values[0], condition.Values[1])
+		}
+	default:
+		if condition.Value == nil {
+			return condition, fmt.Errorf("condition %q requires a value", condition.Type)
+		}
+		value, err := coerceValue(kind, condition.Value, conditionType == "eq")
+		if err != nil {
+			return condition, fmt.Errorf("condition %q: %w", condition.Type, err)
+		}
+		coerced.Value = value
+	}
+
+	if conditionType == "regex" {
+		pattern := coerced.Value.(string)
+		if condition.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return condition, fmt.Errorf("condition %q: %w", condition.Type, err)
+		}
+	}
+	return coerced, nil
+}
+
+// coerceValue converts v for a field of the given kind. Status classes are
+// only meaningful for equality and inclusion.
+func coerceValue(kind fieldKind, v interface{}, allowClass bool) (interface{}, error) {
+	switch kind {
+	case statusField:
+		if s, ok := v.(string); ok {
+			if statusClass.MatchString(s) {
+				if !allowClass {
+					return nil, fmt.Errorf("status class %q can only be used with eq and in", s)
+				}
+				return strings.ToLower(s), nil
+			}
+			n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil || n != math.Trunc(n) {
+				return nil, fmt.Errorf("%q is not a status code", s)
+			}
+			return n, nil
+		}
+		if n, ok := toFloat64(v); ok && n == math.Trunc(n) {
+			if _, isDuration := v.(time.Duration); !isDuration {
+				return n, nil
+			}
+		}
+		return nil, fmt.Errorf("%v is not a status code", v)
+	case durationField:
+		if s, ok := v.(string); ok {
+			d, err := time.ParseDuration(strings.TrimSpace(s))
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a duration", s)
+			}
+			return d.Seconds(), nil
+		}
+		if n, ok := toFloat64(v); ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("%v is not a duration", v)
+	case stringField:
+		switch value := v.(type) {
+		case string:
+			return value, nil
+		case bool:
+			return strconv.FormatBool(value), nil
+		}
+		if n, ok := toFloat64(v); ok {
+			return strconv.FormatFloat(n, 'f', -1, 64), nil
+		}
+		return nil, fmt.Errorf("%v is not a string", v)
+	case numberField:
+		if s, ok := v.(string); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a number", s)
+			}
+			return n, nil
+		}
+		if n, ok := toFloat64(v); ok {
+			if _, isDuration := v.(time.Duration); !isDuration {
+				return n, nil
+			}
+		}
+		return nil, fmt.Errorf("%v is not a number", v)
+	case boolField:
+		switch value := v.(type) {
+		case bool:
+			return value, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("%v is not true or false", v)
+	}
+	return nil, fmt.Errorf("unknown field kind %d", kind)
+}
+
+// Validate reports every condition of the check that can never be evaluated,
+// such as an unknown condition type or a value of the wrong type, so that
+// mistakes surface when the configuration is loaded rather than as failing
+// checks.
+func Validate(check Check) error {
+	var errs []error
+	validate := func(name string, kind fieldKind, condition *Condition) {
+		if condition == nil {
+			return
+		}
+		if _, err := coerceCondition(kind, *condition); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	var walk func(name string, assertion Assertion)
+	walk = func(name string, assertion Assertion) {
+		empty := true
+		for i, condition := range assertion.conditions() {
+			validate(name+"."+fields[i].name, fields[i].kind, condition)
+			if condition != nil {
+				empty = false
+			}
+		}
+		for i, a := range assertion.AllOf {
+			walk(fmt.Sprintf("%s.all_of[%d]", name, i), a)
+		}
+		for i, a := range assertion.AnyOf {
+			walk(fmt.Sprintf("%s.any_of[%d]", name, i), a)
+		}
+		if assertion.Not != nil {
+			walk(name+".not", *assertion.Not)
+		}
+		if empty && len(assertion.AllOf) == 0 && len(assertion.AnyOf) == 0 && assertion.Not == nil {
+			errs = append(errs, fmt.Errorf("%s: empty assertion", name))
+		}
+	}
+
+	for i, condition := range check.conditions() {
+		validate(fields[i].name, fields[i].kind, condition)
+	}
+	if check.Assert != nil {
+		walk("assert", *check.Assert)
+	}
+	return errors.Join(errs...)
+}
@@ -2,106 +2,380 @@ package checker
 
 import (
 	"fmt"
+	"reflect"
 	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+// maxActualLength caps how much of a response body is quoted in assertion
+// results.
+const maxActualLength = 100
+
+// EvaluateCheck stops at the first failing condition unless the check asks
+// for every assertion to be evaluated. Either way, the result lists the
+// assertions that were evaluated.
 func EvaluateCheck(check Check, response Response) CheckResult {
-	if check.HTTPStatus != nil {
-		result := evaluateCondition("HTTP Status", *check.HTTPStatus, response.StatusCode)
-		if !result.Success {
-			return result
+	e := &evaluator{response: response, all: check.EvaluateAll}
+
+	var steps []func() CheckResult
+	for i, condition := range check.conditions() {
+		f, condition := fields[i], condition
+		steps = append(steps, func() CheckResult { return e.condition(f.name, f, condition) })
+	}
+	steps = append(steps, func() CheckResult {
+		if check.Assert == nil {
+			return CheckResult{Success: true}
+		}
+		return e.assertion("assert", *check.Assert)
+	})
+
+	var failures []string
+	for _, step := range steps {
+		result := step()
+		if result.Success {
+			continue
 		}
+		if !e.all {
+			return CheckResult{Success: false, Message: result.Message, Assertions: e.results}
+		}
+		failures = append(failures, result.Message)
 	}
 
-	if check.ResponseTime != nil {
-		result := evaluateCondition("Response Time", *check.ResponseTime, response.Duration.Seconds())
-		if !result.Success {
-			return result
+	if len(failures) > 0 {
+		return CheckResult{Success: false, Message: strings.Join(failures, "; "), Assertions: e.results}
+	}
+	return CheckResult{Success: true, Message: "All checks passed", Assertions: e.results}
+}
+
+// evaluator records the result of every condition and combinator it
+// evaluates.
+type evaluator struct {
+	response Response
+	all      bool
+	results  []AssertionResult
+}
+
+// condition evaluates condition against the response field f and records
+// the result under name. A nil condition passes without being recorded.
+func (e *evaluator) condition(name string, f field, condition *Condition) CheckResult {
+	if condition == nil {
+		return CheckResult{Success: true}
+	}
+
+	value, actual := f.value(e.response)
+	var result CheckResult
+	if coerced, err := coerceCondition(f.kind, *condition); err != nil {
+		result = CheckResult{Success: false, Message: fmt.Sprintf("%s: %v", f.label, err)}
+	} else {
+		result = evaluateCondition(f.label, coerced, value)
+	}
+	e.results = append(e.results, AssertionResult{
+		Name:      name,
+		Condition: condition.Type,
+		Expected:  describeExpected(*condition),
+		Actual:    actual,
+		Success:   result.Success,
+		Message:   result.Message,
+	})
+	return result
+}
+
+func (e *evaluator) assertion(name string, assertion Assertion) CheckResult {
+	var results []CheckResult
+	for i, condition := range assertion.conditions() {
+		if condition != nil {
+			results = append(results, e.condition(name+"."+fields[i].name, fields[i], condition))
+		}
+	}
+	if len(assertion.AllOf) > 0 {
+		results = append(results, e.allOf(name+".all_of", assertion.AllOf))
+	}
+	if len(assertion.AnyOf) > 0 {
+		results = append(results, e.anyOf(name+".any_of", assertion.AnyOf))
+	}
+	if assertion.Not != nil {
+		results = append(results, e.not(name+".not", *assertion.Not))
+	}
+
+	switch len(results) {
+	case 0:
+		result := CheckResult{Success: false, Message: "Empty assertion"}
+		e.record(name, "", result)
+		return result
+	case 1:
+		return results[0]
+	default:
+		return allPassed(results)
+	}
+}
+
+func (e *evaluator) allOf(name string, assertions []Assertion) CheckResult {
+	results := make([]CheckResult, 0, len(assertions))
+	for i, assertion := range assertions {
+		results = append(results, e.assertion(fmt.Sprintf("%s[%d]", name, i), assertion))
+	}
+	result := allPassed(results)
+	e.record(name, "all_of", result)
+	return result
+}
+
+func (e *evaluator) anyOf(name string, assertions []Assertion) CheckResult {
+	var passed *CheckResult
+	failures := make([]string, 0, len(assertions))
+	for i, assertion := range assertions {
+		result := e.assertion(fmt.Sprintf("%s[%d]", name, i), assertion)
+		if result.Success {
+			if passed == nil {
+				passed = &result
+			}
+			if !e.all {
+				break
+			}
+			continue
 		}
+		failures = append(failures, "("+result.Message+")")
+	}
+
+	result := CheckResult{Success: false, Message: "None of the alternatives passed: " + strings.Join(failures, " or ")}
+	if passed != nil {
+		result = *passed
+	}
+	e.record(name, "any_of", result)
+	return result
+}
+
+func (e *evaluator) not(name string, assertion Assertion) CheckResult {
+	inner := e.assertion(name, assertion)
+	result := CheckResult{Success: true, Message: fmt.Sprintf("Negated assertion failed as expected (%s)", inner.Message)}
+	if inner.Success {
+		result = CheckResult{Success: false, Message: fmt.Sprintf("Expected assertion to fail, but it passed (%s)", inner.Message)}
 	}
+	e.record(name, "not", result)
+	return result
+}
+
+// record adds the outcome of a combinator, which has no expected or actual
+// value of its own.
+func (e *evaluator) record(name, combinator string, result CheckResult) {
+	e.results = append(e.results, AssertionResult{
+		Name:      name,
+		Condition: combinator,
+		Success:   result.Success,
+		Message:   result.Message,
+	})
+}
 
-	if check.ResponseBody != nil {
-		result := evaluateCondition("Response Body", *check.ResponseBody, response.Body)
+// allPassed succeeds if every result did, and otherwise explains each
+// failure.
+func allPassed(results []CheckResult) CheckResult {
+	var failures []string
+	for _, result := range results {
 		if !result.Success {
-			return result
+			failures = append(failures, result.Message)
 		}
 	}
+	switch len(failures) {
+	case 0:
+		return CheckResult{Success: true, Message: "All assertions passed"}
+	case 1:
+		return CheckResult{Success: false, Message: failures[0]}
+	default:
+		return CheckResult{Success: false, Message: strings.Join(failures, "; ")}
+	}
+}
+
+func describeExpected(condition Condition) string {
+	if condition.Values != nil {
+		return fmt.Sprintf("%s %v", condition.Type, condition.Values)
+	}
+	return fmt.Sprintf("%s %v", condition.Type, condition.Value)
+}
 
-	return CheckResult{Success: true, Message: "All checks passed"}
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
 }
 
+// statusClass matches status code class shorthands such as 2xx.
+var statusClass = regexp.MustCompile(`^[1-5][xX][xX]$`)
+
 func evaluateCondition(checkType string, condition Condition, value interface{}) CheckResult {
-	switch condition.Type {
+	// not_eq, not_in, not_contains and so on invert the condition they name
+	conditionType, negate := condition.Type, false
+	if base, ok := strings.CutPrefix(conditionType, "not_"); ok && negatable[base] {
+		conditionType, negate = base, true
+	}
+
+	switch conditionType {
 	case "eq":
-		return checkEquality(checkType, condition.Value, value)
+		return checkEquality(checkType, condition, value, negate)
 	case "in":
-		return checkInclusion(checkType, condition.Values, value)
-	case "contains":
-		return checkContains(checkType, condition.Value, value)
+		return checkInclusion(checkType, condition, value, negate)
+	case "contains", "starts_with", "ends_with":
+		return checkSubstring(checkType, conditionType, condition, value, negate)
 	case "regex":
-		return checkRegex(checkType, condition.Value, value)
-	case "below", "above":
+		return checkRegex(checkType, condition, value, negate)
+	case "below", "above", "lte", "gte", "between":
 		return evaluateThreshold(checkType, condition, value)
 	default:
 		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Unknown condition type: %s", checkType, condition.Type)}
 	}
 }
 
-func checkEquality(checkType string, expected, actual interface{}) CheckResult {
-	if expected == actual {
+var negatable = map[string]bool{
+	"eq":          true,
+	"in":          true,
+	"contains":    true,
+	"starts_with": true,
+	"ends_with":   true,
+	"regex":       true,
+}
+
+func checkEquality(checkType string, condition Condition, actual interface{}, negate bool) CheckResult {
+	equal := valuesEqual(condition.Value, actual, condition.IgnoreCase)
+	switch {
+	case equal && !negate:
 		return CheckResult{Success: true, Message: fmt.Sprintf("%s: Equality check passed", checkType)}
+	case !equal && negate:
+		return CheckResult{Success: true, Message: fmt.Sprintf("%s: Inequality check passed", checkType)}
+	case negate:
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Expected a value other than %v", checkType, condition.Value)}
+	default:
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Expected %v, got %v", checkType, condition.Value, actual)}
+	}
+}
+
+func checkInclusion(checkType string, condition Condition, actual interface{}, negate bool) CheckResult {
+	included := false
+	for _, v := range condition.Values {
+		if valuesEqual(v, actual, condition.IgnoreCase) {
+			included = true
+			break
+		}
+	}
+	switch {
+	case included && !negate:
+		return CheckResult{Success: true, Message: fmt.Sprintf("%s: Inclusion check passed", checkType)}
+	case !included && negate:
+		return CheckResult{Success: true, Message: fmt.Sprintf("%s: Exclusion check passed", checkType)}
+	case negate:
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Value %v in disallowed set %v", checkType, actual, condition.Values)}
+	default:
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Value %v not in allowed set %v", checkType, actual, condition.Values)}
 	}
-	return CheckResult{Success: false, Message: fmt.Sprintf("%s: Expected %v, got %v", checkType, expected, actual)}
 }
 
-func checkInclusion(checkType string, expectedValues []interface{}, actual interface{}) CheckResult {
-	for _, v := range expectedValues {
-		if v == actual {
-			return CheckResult{Success: true, Message: fmt.Sprintf("%s: Inclusion check passed", checkType)}
+// valuesEqual compares numbers by value regardless of their type, strings
+// optionally ignoring case, and status classes such as 2xx against numbers.
+func valuesEqual(expected, actual interface{}, ignoreCase bool) bool {
+	if class, ok := expected.(string); ok && statusClass.MatchString(class) {
+		if code, ok := toFloat64(actual); ok {
+			return int(code)/100 == int(class[0]-'0')
+		}
+	}
+	if e, ok := toFloat64(expected); ok {
+		a, ok := toFloat64(actual)
+		return ok && e == a
+	}
+	e, okExpected := expected.(string)
+	a, okActual := actual.(string)
+	if okExpected && okActual {
+		if ignoreCase {
+			return strings.EqualFold(e, a)
 		}
+		return e == a
 	}
-	return CheckResult{Success: false, Message: fmt.Sprintf("%s: Value %v not in allowed set %v", checkType, actual, expectedValues)}
+	return reflect.DeepEqual(expected, actual)
 }
 
-func checkContains(checkType string, expected, actual interface{}) CheckResult {
-	strExpected, okExpected := expected.(string)
+func checkSubstring(checkType, conditionType string, condition Condition, actual interface{}, negate bool) CheckResult {
+	strExpected, okExpected := condition.Value.(string)
 	strActual, okActual := actual.(string)
 	if !okExpected || !okActual {
-		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Contains check requires string values", checkType)}
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: %s check requires string values", checkType, condition.Type)}
+	}
+	if condition.IgnoreCase {
+		strExpected, strActual = strings.ToLower(strExpected), strings.ToLower(strActual)
+	}
+
+	var matched bool
+	var name, verb string
+	switch conditionType {
+	case "contains":
+		matched, name, verb = strings.Contains(strActual, strExpected), "Contains", "contain"
+	case "starts_with":
+		matched, name, verb = strings.HasPrefix(strActual, strExpected), "Starts with", "start with"
+	case "ends_with":
+		matched, name, verb = strings.HasSuffix(strActual, strExpected), "Ends with", "end with"
 	}
-	if strings.Contains(strActual, strExpected) {
-		return CheckResult{Success: true, Message: fmt.Sprintf("%s: Contains check passed", checkType)}
+
+	switch {
+	case matched && !negate:
+		return CheckResult{Success: true, Message: fmt.Sprintf("%s: %s check passed", checkType, name)}
+	case !matched && negate:
+		return CheckResult{Success: true, Message: fmt.Sprintf("%s: String does not %s '%s'", checkType, verb, condition.Value)}
+	case negate:
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: String %s '%s'", checkType, strings.ToLower(name), condition.Value)}
+	default:
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: String does not %s '%s'", checkType, verb, condition.Value)}
 	}
-	return CheckResult{Success: false, Message: fmt.Sprintf("%s: String does not contain '%s'", checkType, strExpected)}
 }
 
-func checkRegex(checkType string, pattern, actual interface{}) CheckResult {
-	strPattern, okPattern := pattern.(string)
+func checkRegex(checkType string, condition Condition, actual interface{}, negate bool) CheckResult {
+	strPattern, okPattern := condition.Value.(string)
 	strActual, okActual := actual.(string)
 	if !okPattern || !okActual {
 		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Regex check requires string values", checkType)}
 	}
-	match, err := regexp.MatchString(strPattern, strActual)
+	pattern := strPattern
+	if condition.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	match, err := regexp.MatchString(pattern, strActual)
 	if err != nil {
 		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Regex error: %v", checkType, err)}
 	}
-	if match {
+	switch {
+	case match != negate:
 		return CheckResult{Success: true, Message: fmt.Sprintf("%s: Regex check passed", checkType)}
+	case negate:
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: String matches pattern '%s'", checkType, strPattern)}
+	default:
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: String does not match pattern '%s'", checkType, strPattern)}
 	}
-	return CheckResult{Success: false, Message: fmt.Sprintf("%s: String does not match pattern '%s'", checkType, strPattern)}
 }
 
 func evaluateThreshold(checkType string, condition Condition, value interface{}) CheckResult {
-	thresholdValue, ok := toFloat64(condition.Value)
+	actualValue, ok := toFloat64(value)
 	if !ok {
-		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Invalid threshold value: %v", checkType, condition.Value)}
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Invalid actual value: %v", checkType, value)}
 	}
 
-	actualValue, ok := toFloat64(value)
+	if condition.Type == "between" {
+		if len(condition.Values) != 2 {
+			return CheckResult{Success: false, Message: fmt.Sprintf("%s: Between requires two values, got %v", checkType, condition.Values)}
+		}
+		low, okLow := toFloat64(condition.Values[0])
+		high, okHigh := toFloat64(condition.Values[1])
+		if !okLow || !okHigh {
+			return CheckResult{Success: false, Message: fmt.Sprintf("%s: Invalid range: %v", checkType, condition.Values)}
+		}
+		if actualValue >= low && actualValue <= high {
+			return CheckResult{Success: true, Message: fmt.Sprintf("%s: Range check passed", checkType)}
+		}
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Value %v not between %v and %v", checkType, actualValue, low, high)}
+	}
+
+	thresholdValue, ok := toFloat64(condition.Value)
 	if !ok {
-		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Invalid actual value: %v", checkType, value)}
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Invalid threshold value: %v", checkType, condition.Value)}
 	}
 
 	switch condition.Type {
@@ -115,6 +389,16 @@ func evaluateThreshold(checkType string, condition Condition, value interface{})
 			return CheckResult{Success: true, Message: fmt.Sprintf("%s: Above threshold check passed", checkType)}
 		}
 		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Value %v not above threshold %v", checkType, actualValue, thresholdValue)}
+	case "lte":
+		if actualValue <= thresholdValue {
+			return CheckResult{Success: true, Message: fmt.Sprintf("%s: At most threshold check passed", checkType)}
+		}
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Value %v above threshold %v", checkType, actualValue, thresholdValue)}
+	case "gte":
+		if actualValue >= thresholdValue {
+			return CheckResult{Success: true, Message: fmt.Sprintf("%s: At least threshold check passed", checkType)}
+		}
+		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Value %v below threshold %v", checkType, actualValue, thresholdValue)}
 	default:
 		return CheckResult{Success: false, Message: fmt.Sprintf("%s: Unknown threshold type: %s", checkType, condition.Type)}
 	}
@@ -128,8 +412,24 @@ func toFloat64(v interface{}) (float64, bool) {
 		return float64(value), true
 	case int:
 		return float64(value), true
+	case int8:
+		return float64(value), true
+	case int16:
+		return float64(value), true
+	case int32:
+		return float64(value), true
 	case int64:
 		return float64(value), true
+	case uint:
+		return float64(value), true
+	case uint8:
+		return float64(value), true
+	case uint16:
+		return float64(value), true
+	case uint32:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
 	case time.Duration:
 		return value.Seconds(), true
 	default:
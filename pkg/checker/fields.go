@@ -0,0 +1,67 @@
+package checker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// field is a property of a response that conditions can test.
+type field struct {
+	// name is the key of the condition in a check, e.g. http_status.
+	name string
+	// label names the field in messages, e.g. HTTP Status.
+	label string
+	kind  fieldKind
+	// value returns the value conditions are compared with and how it is
+	// shown in assertion results.
+	value func(Response) (interface{}, string)
+}
+
+// fields are evaluated in this order. conditions methods return the
+// conditions of a check or assertion in the same order.
+var fields = []field{
+	{"http_status", "HTTP Status", statusField, func(r Response) (interface{}, string) {
+		return r.StatusCode, fmt.Sprint(r.StatusCode)
+	}},
+	{"response_time", "Response Time", durationField, func(r Response) (interface{}, string) {
+		return r.Duration.Seconds(), r.Duration.String()
+	}},
+	{"response_body", "Response Body", stringField, func(r Response) (interface{}, string) {
+		return r.Body, truncate(r.Body, maxActualLength)
+	}},
+	{"redirect_count", "Redirect Count", numberField, func(r Response) (interface{}, string) {
+		return len(r.Redirects), strconv.Itoa(len(r.Redirects))
+	}},
+	{"first_redirect_status", "First Redirect Status", statusField, func(r Response) (interface{}, string) {
+		if len(r.Redirects) == 0 {
+			return 0, "no redirect"
+		}
+		return r.Redirects[0].StatusCode, fmt.Sprint(r.Redirects[0].StatusCode)
+	}},
+	{"final_url", "Final URL", stringField, func(r Response) (interface{}, string) {
+		return r.URL, truncate(r.URL, maxActualLength)
+	}},
+	{"https_upgrade", "HTTPS Upgrade", boolField, func(r Response) (interface{}, string) {
+		upgraded := httpsUpgrade(r)
+		return upgraded, strconv.FormatBool(upgraded)
+	}},
+}
+
+// httpsUpgrade reports whether redirects led from an http URL to an https
+// one.
+func httpsUpgrade(r Response) bool {
+	if len(r.Redirects) == 0 {
+		return false
+	}
+	return strings.HasPrefix(strings.ToLower(r.Redirects[0].URL), "http://") &&
+		strings.HasPrefix(strings.ToLower(r.URL), "https://")
+}
+
+func (c Check) conditions() []*Condition {
+	return []*Condition{c.HTTPStatus, c.ResponseTime, c.ResponseBody, c.RedirectCount, c.FirstRedirectStatus, c.FinalURL, c.HTTPSUpgrade}
+}
+
+func (a Assertion) conditions() []*Condition {
+	return []*Condition{a.HTTPStatus, a.ResponseTime, a.ResponseBody, a.RedirectCount, a.FirstRedirectStatus, a.FinalURL, a.HTTPSUpgrade}
+}
@@ -3,31 +3,74 @@ package checker
 import "time"
 
 type Check struct {
-	Path         string
-	HTTPStatus   *Condition
-	ResponseTime *Condition
-	ResponseBody *Condition
+	Path                string
+	HTTPStatus          *Condition
+	ResponseTime        *Condition
+	ResponseBody        *Condition
+	RedirectCount       *Condition
+	FirstRedirectStatus *Condition
+	FinalURL            *Condition
+	HTTPSUpgrade        *Condition
+	Assert              *Assertion
+	// EvaluateAll evaluates every assertion instead of stopping at the
+	// first failure, so that the result reports all of them.
+	EvaluateAll bool
 }
 
-type Condition struct {
-	Type      string
-	Value     interface{}
-	Values    []interface{}
-	Threshold *Threshold
+// Assertion is a node in a tree of conditions. Every part that is set must
+// pass: the conditions, all of AllOf, at least one of AnyOf, and Not must
+// fail.
+type Assertion struct {
+	AllOf               []Assertion
+	AnyOf               []Assertion
+	Not                 *Assertion
+	HTTPStatus          *Condition
+	ResponseTime        *Condition
+	ResponseBody        *Condition
+	RedirectCount       *Condition
+	FirstRedirectStatus *Condition
+	FinalURL            *Condition
+	HTTPSUpgrade        *Condition
 }
 
-type Threshold struct {
-	Type  string
-	Value interface{}
+type Condition struct {
+	Type   string
+	Value  interface{}
+	Values []interface{}
+	// IgnoreCase makes string comparisons case-insensitive.
+	IgnoreCase bool
 }
 
 type Response struct {
 	StatusCode int
 	Body       string
 	Duration   time.Duration
+	// URL is the URL of the final response, after redirects.
+	URL string
+	// Redirects are the redirects that were followed, in order.
+	Redirects []Redirect
+}
+
+// Redirect is a redirect response that was followed to Location.
+type Redirect struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode"`
+	Location   string `json:"location"`
 }
 
 type CheckResult struct {
-	Success bool
-	Message string
+	Success    bool
+	Message    string
+	Assertions []AssertionResult
+}
+
+// AssertionResult is the outcome of a single condition or combinator.
+// Name is the condition's path in the check, e.g. assert.any_of[1].http_status.
+type AssertionResult struct {
+	Name      string `json:"name"`
+	Condition string `json:"condition"`
+	Expected  string `json:"expected,omitempty"`
+	Actual    string `json:"actual,omitempty"`
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
 }
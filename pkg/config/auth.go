@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AuthConfig authenticates the requests of a target or module. Exactly one
+// method must be set.
+type AuthConfig struct {
+	Basic  *BasicAuth    `yaml:"basic,omitempty"`
+	Bearer *SecretRef    `yaml:"bearer,omitempty"`
+	OAuth2 *OAuth2Config `yaml:"oauth2,omitempty"`
+}
+
+type BasicAuth struct {
+	Username string    `yaml:"username"`
+	Password SecretRef `yaml:"password"`
+}
+
+// OAuth2Config requests tokens with the client credentials grant.
+type OAuth2Config struct {
+	TokenURL     string    `yaml:"token_url"`
+	ClientID     string    `yaml:"client_id"`
+	ClientSecret SecretRef `yaml:"client_secret"`
+	Scopes       []string  `yaml:"scopes,omitempty"`
+	// EndpointParams are added to the token request, e.g. audience.
+	EndpointParams map[string]string `yaml:"endpoint_params,omitempty"`
+}
+
+// Validate reports an incomplete auth configuration. Whether the secrets
+// can be read is only known when they are used.
+func (a *AuthConfig) Validate() error {
+	if a == nil {
+		return nil
+	}
+
+	var errs []error
+	methods := 0
+	if a.Basic != nil {
+		methods++
+		if a.Basic.Username == "" {
+			errs = append(errs, errors.New("basic: username is required"))
+		}
+		if err := a.Basic.Password.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("basic: password: %w", err))
+		}
+	}
+	if a.Bearer != nil {
+		methods++
+		if err := a.Bearer.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("bearer: %w", err))
+		}
+	}
+	if a.OAuth2 != nil {
+		methods++
+		if a.OAuth2.TokenURL == "" {
+			errs = append(errs, errors.New("oauth2: token_url is required"))
+		}
+		if a.OAuth2.ClientID == "" {
+			errs = append(errs, errors.New("oauth2: client_id is required"))
+		}
+		if err := a.OAuth2.ClientSecret.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("oauth2: client_secret: %w", err))
+		}
+	}
+	if methods != 1 {
+		errs = append(errs, errors.New("exactly one of basic, bearer or oauth2 is required"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/secrets"
+	"gopkg.in/yaml.v2"
+)
+
+type CollectorConfig struct {
+	LogLevel    string            `yaml:"log_level"`
+	LogFormat   string            `yaml:"log_format"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Storage     StorageConfig     `yaml:"storage"`
+	SLOs        []SLO             `yaml:"slos"`
+	StatusPage  StatusPageConfig  `yaml:"status_page"`
+	RemoteWrite RemoteWriteConfig `yaml:"remote_write"`
+}
+
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+type DatabaseConfig struct {
+	// Backend is either postgres (TimescaleDB, the default) or sqlite.
+	Backend string `yaml:"backend"`
+	// URL is the Postgres connection string. DATABASE_URL takes precedence.
+	URL string `yaml:"url"`
+	// Path is the SQLite database file.
+	Path string `yaml:"path"`
+}
+
+// StorageConfig controls rollups, compression and retention. Durations
+// accept a day unit, e.g. 30d. Empty values keep data forever and disable
+// compression.
+type StorageConfig struct {
+	CompressAfter string          `yaml:"compress_after"`
+	Retention     RetentionConfig `yaml:"retention"`
+}
+
+type RetentionConfig struct {
+	Raw    string `yaml:"raw"`
+	Minute string `yaml:"1m"`
+	Hour   string `yaml:"1h"`
+}
+
+type SLO struct {
+	Target string `yaml:"target"`
+	// Objective is the availability target in percent, e.g. 99.9.
+	Objective float64 `yaml:"objective"`
+	// Window is the period the objective applies to, e.g. 30d or month.
+	Window string `yaml:"window"`
+}
+
+// StatusPageConfig groups targets into the components shown on the public
+// status page. Only targets listed here are public.
+type StatusPageConfig struct {
+	Title      string            `yaml:"title"`
+	Components []StatusComponent `yaml:"components"`
+}
+
+type StatusComponent struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Targets     []string `yaml:"targets"`
+}
+
+// RemoteWriteConfig forwards ingested results to a Prometheus remote_write
+// endpoint. It is disabled without a URL.
+type RemoteWriteConfig struct {
+	URL string `yaml:"url"`
+	// Headers are added to every request, e.g. X-Scope-OrgID for Mimir.
+	// Values can be secret references, e.g. env:MIMIR_TOKEN.
+	Headers map[string]Secret `yaml:"headers"`
+	// ExternalLabels are added to every series.
+	ExternalLabels map[string]string `yaml:"external_labels"`
+	BatchSize      int               `yaml:"batch_size,omitempty"`
+	FlushInterval  time.Duration     `yaml:"flush_interval,omitempty"`
+	QueueSize      int               `yaml:"queue_size,omitempty"`
+	MaxRetries     int               `yaml:"max_retries,omitempty"`
+	Timeout        time.Duration     `yaml:"timeout,omitempty"`
+}
+
+// LoadCollectorConfig reads the collector configuration. A missing file is
+// not an error; the collector then runs with defaults.
+func LoadCollectorConfig(path string) (*CollectorConfig, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &CollectorConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = expandEnv(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg CollectorConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	secrets.RegisterURL(cfg.Database.URL)
+	secrets.RegisterURL(cfg.RemoteWrite.URL)
+	for _, value := range cfg.RemoteWrite.Headers {
+		value.register()
+	}
+
+	switch cfg.Database.Backend {
+	case "", BackendPostgres, BackendSQLite:
+	default:
+		return nil, fmt.Errorf("unknown database backend %q, expected %s or %s", cfg.Database.Backend, BackendPostgres, BackendSQLite)
+	}
+
+	return &cfg, nil
+}
@@ -1,43 +1,209 @@
 package config
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	LogLevel string   `yaml:"log_level"`
-	Targets  []Target `yaml:"targets"`
+	LogLevel   string           `yaml:"log_level"`
+	LogFormat  string           `yaml:"log_format"`
+	Scheduler  SchedulerConfig  `yaml:"scheduler"`
+	HTTPClient HTTPClientConfig `yaml:"http_client"`
+	Health     HealthConfig     `yaml:"health"`
+	Targets    []Target         `yaml:"targets"`
+	// Modules are named checks for the /probe endpoint, where the target
+	// is supplied per request instead of being configured here.
+	Modules map[string]Module `yaml:"modules,omitempty"`
+}
+
+// HealthConfig overrides the built-in health check settings, keyed by
+// check name (e.g. last_run, collector_reachable).
+type HealthConfig struct {
+	Checks map[string]HealthCheckConfig `yaml:"checks"`
+}
+
+type HealthCheckConfig struct {
+	Liveness  *bool         `yaml:"liveness,omitempty"`
+	Readiness *bool         `yaml:"readiness,omitempty"`
+	Critical  *bool         `yaml:"critical,omitempty"`
+	Timeout   time.Duration `yaml:"timeout,omitempty"`
+	CacheTTL  time.Duration `yaml:"cache_ttl,omitempty"`
+}
+
+type SchedulerConfig struct {
+	// Workers is the number of checks that may run at the same time.
+	Workers int `yaml:"workers"`
+	// Jitter spreads runs by offsetting each check by a random fraction
+	// (0-1) of its target's frequency.
+	Jitter float64 `yaml:"jitter"`
+}
+
+type HTTPClientConfig struct {
+	Timeout             time.Duration `yaml:"timeout,omitempty"`
+	MaxIdleConns        int           `yaml:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host,omitempty"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout,omitempty"`
+	InsecureSkipVerify  *bool         `yaml:"insecure_skip_verify,omitempty"`
+	DisableKeepAlives   bool          `yaml:"disable_keep_alives,omitempty"`
 }
 
 type Target struct {
-	Name              string        `yaml:"name"`
-	URL               string        `yaml:"url"`
-	Frequency         time.Duration `yaml:"frequency"`
-	FailureTolerance  int           `yaml:"failure_tolerance"`
-	RecoveryThreshold int           `yaml:"recovery_threshold"`
-	Checks            []Check       `yaml:"checks"`
+	Name              string            `yaml:"name"`
+	URL               string            `yaml:"url"`
+	Frequency         time.Duration     `yaml:"frequency"`
+	FailureTolerance  int               `yaml:"failure_tolerance"`
+	RecoveryThreshold int               `yaml:"recovery_threshold"`
+	HTTPClient        *HTTPClientConfig `yaml:"http_client,omitempty"`
+	Auth              *AuthConfig       `yaml:"auth,omitempty"`
+	Checks            []Check           `yaml:"checks"`
 }
 
 type Check struct {
-	Path         string     `yaml:"path"`
-	HTTPStatus   *Condition `yaml:"http_status,omitempty"`
-	ResponseTime *Condition `yaml:"response_time,omitempty"`
-	ResponseBody *Condition `yaml:"response_body,omitempty"`
+	// Name identifies the check in results, metrics and events. It defaults
+	// to the path and must be unique within the target.
+	Name string `yaml:"name,omitempty"`
+	Path string `yaml:"path"`
+	// MaxConcurrency caps how many runs of this check may be in flight at
+	// once. Runs that come due while the limit is reached are skipped.
+	MaxConcurrency int `yaml:"max_concurrency,omitempty"`
+	// FollowRedirects is true (the default, up to 10 redirects), false or
+	// the maximum number of redirects to follow.
+	FollowRedirects *FollowRedirects `yaml:"follow_redirects,omitempty"`
+	HTTPStatus      *Condition       `yaml:"http_status,omitempty"`
+	ResponseTime    *Condition       `yaml:"response_time,omitempty"`
+	ResponseBody    *Condition       `yaml:"response_body,omitempty"`
+	// Conditions on the redirects that were followed.
+	RedirectCount       *Condition `yaml:"redirect_count,omitempty"`
+	FirstRedirectStatus *Condition `yaml:"first_redirect_status,omitempty"`
+	FinalURL            *Condition `yaml:"final_url,omitempty"`
+	HTTPSUpgrade        *Condition `yaml:"https_upgrade,omitempty"`
+	// Assert combines conditions with all_of, any_of and not. It must pass
+	// in addition to the conditions above.
+	Assert *Assertion `yaml:"assert,omitempty"`
+	// EvaluateAll reports every failed assertion instead of only the first.
+	EvaluateAll bool `yaml:"evaluate_all,omitempty"`
+	// Steps turn the check into a transaction of requests that run in
+	// order. Path then only names the check.
+	Steps []Step `yaml:"steps,omitempty"`
 }
 
-type Condition struct {
-	Type   string        `yaml:"condition"`
-	Value  interface{}   `yaml:"value,omitempty"`
-	Values []interface{} `yaml:"values,omitempty"`
+// ID returns the name that identifies the check within its target.
+func (c Check) ID() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Path
+}
+
+// Step is one request of a multi-step check. Path, headers and body are
+// text/template templates over the variables captured by earlier steps,
+// e.g. {{.token}}.
+type Step struct {
+	Name    string            `yaml:"name"`
+	Method  string            `yaml:"method,omitempty"`
+	Path    string            `yaml:"path"`
+	Headers map[string]Secret `yaml:"headers,omitempty"`
+	Body    string            `yaml:"body,omitempty"`
+	// FollowRedirects overrides the check's redirect policy for this step.
+	FollowRedirects     *FollowRedirects   `yaml:"follow_redirects,omitempty"`
+	HTTPStatus          *Condition         `yaml:"http_status,omitempty"`
+	ResponseTime        *Condition         `yaml:"response_time,omitempty"`
+	ResponseBody        *Condition         `yaml:"response_body,omitempty"`
+	RedirectCount       *Condition         `yaml:"redirect_count,omitempty"`
+	FirstRedirectStatus *Condition         `yaml:"first_redirect_status,omitempty"`
+	FinalURL            *Condition         `yaml:"final_url,omitempty"`
+	HTTPSUpgrade        *Condition         `yaml:"https_upgrade,omitempty"`
+	Assert              *Assertion         `yaml:"assert,omitempty"`
+	Capture             map[string]Capture `yaml:"capture,omitempty"`
+}
+
+// Capture extracts a variable from a step's response. Exactly one source
+// must be set.
+type Capture struct {
+	// JSON is a dot-separated path into a JSON body, e.g. data.items.0.id.
+	JSON string `yaml:"json,omitempty"`
+	// Header is the name of a response header.
+	Header string `yaml:"header,omitempty"`
+	// Regex is matched against the body. The first group is captured, or
+	// the whole match if there is none.
+	Regex string `yaml:"regex,omitempty"`
+}
+
+// DefaultMaxRedirects is how many redirects a check follows by default.
+const DefaultMaxRedirects = 10
+
+// FollowRedirects is written as true, false or a number. Max is the number
+// of redirects that are followed; 0 returns the first redirect response.
+type FollowRedirects struct {
+	Max int
+}
+
+func (f *FollowRedirects) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var follow bool
+	if err := unmarshal(&follow); err == nil {
+		f.Max = 0
+		if follow {
+			f.Max = DefaultMaxRedirects
+		}
+		return nil
+	}
+	if err := unmarshal(&f.Max); err != nil || f.Max < 0 {
+		return fmt.Errorf("follow_redirects must be true, false or a number of redirects")
+	}
+	return nil
+}
+
+func (f *FollowRedirects) UnmarshalJSON(data []byte) error {
+	return yaml.Unmarshal(data, f)
+}
+
+func (f FollowRedirects) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Itoa(f.Max)), nil
+}
+
+// Limit is the number of redirects to follow. f may be nil.
+func (f *FollowRedirects) Limit() int {
+	if f == nil {
+		return DefaultMaxRedirects
+	}
+	return f.Max
+}
+
+// Assertion is a node in a tree of conditions. A node with several parts
+// set passes only if all of them do.
+type Assertion struct {
+	AllOf               []Assertion `yaml:"all_of,omitempty"`
+	AnyOf               []Assertion `yaml:"any_of,omitempty"`
+	Not                 *Assertion  `yaml:"not,omitempty"`
+	HTTPStatus          *Condition  `yaml:"http_status,omitempty"`
+	ResponseTime        *Condition  `yaml:"response_time,omitempty"`
+	ResponseBody        *Condition  `yaml:"response_body,omitempty"`
+	RedirectCount       *Condition  `yaml:"redirect_count,omitempty"`
+	FirstRedirectStatus *Condition  `yaml:"first_redirect_status,omitempty"`
+	FinalURL            *Condition  `yaml:"final_url,omitempty"`
+	HTTPSUpgrade        *Condition  `yaml:"https_upgrade,omitempty"`
+}
+
+// Module is a reusable check definition. Path, if set, is appended to the
+// target URL given to /probe.
+type Module struct {
+	Timeout    time.Duration     `yaml:"timeout,omitempty"`
+	HTTPClient *HTTPClientConfig `yaml:"http_client,omitempty"`
+	Auth       *AuthConfig       `yaml:"auth,omitempty"`
+	Check      `yaml:",inline"`
 }
 
-type Threshold struct {
-	Type  string      `yaml:"type"`
-	Value interface{} `yaml:"value"`
+type Condition struct {
+	Type       string        `yaml:"condition"`
+	Value      interface{}   `yaml:"value,omitempty"`
+	Values     []interface{} `yaml:"values,omitempty"`
+	IgnoreCase bool          `yaml:"ignore_case,omitempty"`
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -52,30 +218,101 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	data, err = expandEnv(data)
+	if err != nil {
+		return nil, err
+	}
+
 	var cfg Config
 	err = yaml.Unmarshal(data, &cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	for _, target := range cfg.Targets {
+		target.RegisterSecrets()
+	}
+	for _, module := range cfg.Modules {
+		module.registerSecrets()
+	}
+
 	// Convert duration strings to time.Duration
 	for i, target := range cfg.Targets {
 		target.Frequency, err = time.ParseDuration(target.Frequency.String())
 		if err != nil {
 			return nil, err
 		}
-		for j, check := range target.Checks {
-			if check.ResponseTime != nil && check.ResponseTime.Value != nil {
-				if durationStr, ok := check.ResponseTime.Value.(string); ok {
-					duration, err := time.ParseDuration(durationStr)
-					if err != nil {
-						return nil, err
-					}
-					cfg.Targets[i].Checks[j].ResponseTime.Value = duration
-				}
+		for j := range target.Checks {
+			if err := parseResponseTime(&cfg.Targets[i].Checks[j]); err != nil {
+				return nil, err
 			}
 		}
 	}
+	for name, module := range cfg.Modules {
+		if err := parseResponseTime(&module.Check); err != nil {
+			return nil, fmt.Errorf("module %s: %w", name, err)
+		}
+		cfg.Modules[name] = module
+	}
 
 	return &cfg, nil
 }
+
+func parseResponseTime(check *Check) error {
+	if err := parseDurationCondition(check.ResponseTime); err != nil {
+		return err
+	}
+	for i := range check.Steps {
+		if err := parseDurationCondition(check.Steps[i].ResponseTime); err != nil {
+			return err
+		}
+		if err := parseAssertionDurations(check.Steps[i].Assert); err != nil {
+			return err
+		}
+	}
+	return parseAssertionDurations(check.Assert)
+}
+
+func parseAssertionDurations(assertion *Assertion) error {
+	if assertion == nil {
+		return nil
+	}
+	if err := parseDurationCondition(assertion.ResponseTime); err != nil {
+		return err
+	}
+	for i := range assertion.AllOf {
+		if err := parseAssertionDurations(&assertion.AllOf[i]); err != nil {
+			return err
+		}
+	}
+	for i := range assertion.AnyOf {
+		if err := parseAssertionDurations(&assertion.AnyOf[i]); err != nil {
+			return err
+		}
+	}
+	return parseAssertionDurations(assertion.Not)
+}
+
+func parseDurationCondition(condition *Condition) error {
+	if condition == nil {
+		return nil
+	}
+	if durationStr, ok := condition.Value.(string); ok {
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return err
+		}
+		condition.Value = duration
+	}
+	// Bounds of between
+	for i, value := range condition.Values {
+		if durationStr, ok := value.(string); ok {
+			duration, err := time.ParseDuration(durationStr)
+			if err != nil {
+				return err
+			}
+			condition.Values[i] = duration
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration extends time.ParseDuration with a day unit, e.g. 7d.
+func ParseDuration(expr string) (time.Duration, error) {
+	if strings.HasSuffix(expr, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(expr, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", expr)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(expr)
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/secrets"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	envPrefix  = "env:"
+	filePrefix = "file:"
+)
+
+// SecretRef names where a secret is read from, so that it is never stored
+// in the configuration. Exactly one of Env and File must be set. It can
+// also be written as a string, env:NAME or file:PATH.
+type SecretRef struct {
+	Env  string `yaml:"env,omitempty"`
+	File string `yaml:"file,omitempty"`
+}
+
+func (r *SecretRef) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		return r.parse(s)
+	}
+	type plain SecretRef
+	return unmarshal((*plain)(r))
+}
+
+func (r *SecretRef) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return r.parse(s)
+	}
+	type plain SecretRef
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+func (r *SecretRef) parse(s string) error {
+	ref, ok := parseSecretRef(s)
+	if !ok {
+		return fmt.Errorf("secret must be a reference such as %sNAME or %sPATH", envPrefix, filePrefix)
+	}
+	*r = ref
+	return nil
+}
+
+func parseSecretRef(s string) (SecretRef, bool) {
+	if name, ok := strings.CutPrefix(s, envPrefix); ok {
+		return SecretRef{Env: name}, true
+	}
+	if path, ok := strings.CutPrefix(s, filePrefix); ok {
+		return SecretRef{File: path}, true
+	}
+	return SecretRef{}, false
+}
+
+// Value reads the secret. It is read on every use, so rotated secrets are
+// picked up without a reload. Trailing newlines of files are dropped.
+func (r SecretRef) Value() (string, error) {
+	var value string
+	switch {
+	case r.Env != "":
+		v, ok := os.LookupEnv(r.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", r.Env)
+		}
+		value = v
+	case r.File != "":
+		data, err := os.ReadFile(r.File)
+		if err != nil {
+			return "", fmt.Errorf("reading secret: %w", err)
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	default:
+		return "", errors.New("secret has no source")
+	}
+	secrets.Register(value)
+	return value, nil
+}
+
+func (r SecretRef) validate() error {
+	if (r.Env == "") == (r.File == "") {
+		return errors.New("exactly one of env or file is required")
+	}
+	return nil
+}
+
+// Secret is a value that may be sensitive, such as a header value. It is
+// either the value itself or a reference, env:NAME or file:PATH, that is
+// resolved on use. Values written into the configuration are redacted when
+// it is serialized, references are shown as is.
+type Secret string
+
+// IsReference reports whether the secret is read from the environment or a
+// file.
+func (s Secret) IsReference() bool {
+	_, ok := parseSecretRef(string(s))
+	return ok
+}
+
+// Value returns the secret, reading it first if it is a reference.
+func (s Secret) Value() (string, error) {
+	if ref, ok := parseSecretRef(string(s)); ok {
+		return ref.Value()
+	}
+	return string(s), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if s.IsReference() || s == "" {
+		return json.Marshal(string(s))
+	}
+	return json.Marshal(secrets.Redacted)
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	if s.IsReference() || s == "" {
+		return string(s), nil
+	}
+	return secrets.Redacted, nil
+}
+
+// register marks the secret for redaction if it is written into the
+// configuration, along with the environment variables expanded into it.
+// References are registered once they are read.
+func (s Secret) register() {
+	if !s.IsReference() {
+		secrets.Register(string(s))
+		for _, value := range expandedFrom(string(s)) {
+			secrets.Register(value)
+		}
+	}
+}
+
+// RegisterSecrets marks the secrets written into the target, the password
+// of its URL and literal header values, for redaction.
+func (t Target) RegisterSecrets() {
+	secrets.RegisterURL(t.URL)
+	for _, check := range t.Checks {
+		check.registerSecrets()
+	}
+}
+
+func (c Check) registerSecrets() {
+	for _, step := range c.Steps {
+		for _, value := range step.Headers {
+			value.register()
+		}
+	}
+}
+
+// envReference matches ${NAME} and ${NAME:-default}. $${NAME} escapes it.
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expansions maps expanded values to the environment variable values they
+// contain, e.g. "Bearer abc" to "abc".
+var (
+	expansionsMu sync.Mutex
+	expansions   = make(map[string][]string)
+)
+
+func expandedFrom(value string) []string {
+	expansionsMu.Lock()
+	defer expansionsMu.Unlock()
+	return expansions[value]
+}
+
+// expandEnv replaces references to environment variables in the values of
+// a configuration file. The file is parsed first, so that references in
+// comments are ignored and expanded values can't change its structure.
+// Expanded values are only treated as secrets where the configuration
+// takes a Secret or a URL with a password, since most are hosts, ports and
+// names that would otherwise be redacted wherever they appear. Referring to
+// an unset variable without a default is an error.
+func expandEnv(data []byte) ([]byte, error) {
+	// Keep the file as is, and its line numbers in errors, if there's
+	// nothing to expand
+	if !envReference.Match(data) {
+		return data, nil
+	}
+
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	var missing []string
+	document = expandNode(document, &missing)
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("environment variables referenced in config are not set: %s", strings.Join(slices.Compact(missing), ", "))
+	}
+	return yaml.Marshal(document)
+}
+
+func expandNode(node interface{}, missing *[]string) interface{} {
+	switch n := node.(type) {
+	case string:
+		return expandValue(n, missing)
+	case []interface{}:
+		for i := range n {
+			n[i] = expandNode(n[i], missing)
+		}
+	case map[interface{}]interface{}:
+		for key, value := range n {
+			n[key] = expandNode(value, missing)
+		}
+	}
+	return node
+}
+
+// expandValue expands the references in a string value. A value that is a
+// single reference takes the type of what it expands to, so that e.g.
+// port: ${PORT} remains a number.
+func expandValue(value string, missing *[]string) interface{} {
+	whole := false
+	if loc := envReference.FindStringIndex(value); loc != nil {
+		whole = loc[0] == 0 && loc[1] == len(value) && !strings.HasPrefix(value, "$$")
+	}
+
+	var values []string
+	expanded := envReference.ReplaceAllStringFunc(value, func(match string) string {
+		if match[1] == '$' {
+			return match[1:]
+		}
+		groups := envReference.FindStringSubmatch(match)
+		if v, ok := os.LookupEnv(groups[1]); ok {
+			values = append(values, v)
+			return v
+		}
+		if strings.Contains(match, ":-") {
+			return groups[2]
+		}
+		*missing = append(*missing, groups[1])
+		return match
+	})
+	if len(values) > 0 {
+		expansionsMu.Lock()
+		expansions[expanded] = values
+		expansionsMu.Unlock()
+	}
+	if whole {
+		return scalar(expanded)
+	}
+	return expanded
+}
+
+// scalar returns value as a number or boolean if it is written as one, and
+// as a string otherwise. Values that wouldn't be written back the same,
+// such as 007, stay strings.
+func scalar(value string) interface{} {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil && strconv.FormatInt(i, 10) == value {
+		return i
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil && strconv.FormatFloat(f, 'g', -1, 64) == value {
+		return f
+	}
+	if b, err := strconv.ParseBool(value); err == nil && strconv.FormatBool(b) == value {
+		return b
+	}
+	return value
+}
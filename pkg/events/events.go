@@ -0,0 +1,185 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	TypeResult   = "result"
+	TypeState    = "state"
+	TypeIncident = "incident"
+)
+
+type Event struct {
+	ID     uint64      `json:"id"`
+	Type   string      `json:"type"`
+	Target string      `json:"target"`
+	Check  string      `json:"check,omitempty"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data"`
+}
+
+// Filter selects events by target and check. Empty fields match everything.
+type Filter struct {
+	Target string
+	Check  string
+}
+
+func (f Filter) Match(e Event) bool {
+	if f.Target != "" && f.Target != e.Target {
+		return false
+	}
+	if f.Check != "" && f.Check != e.Check {
+		return false
+	}
+	return true
+}
+
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter Filter
+	broker *Broker
+	once   sync.Once
+}
+
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// Broker fans published events out to subscribers and keeps the most recent
+// ones in a ring buffer so clients can resume with Last-Event-ID.
+type Broker struct {
+	mu     sync.Mutex
+	buffer []Event
+	start  int
+	count  int
+	nextID uint64
+	subs   map[*Subscription]struct{}
+}
+
+func NewBroker(size int) *Broker {
+	if size <= 0 {
+		size = 256
+	}
+	return &Broker{
+		buffer: make([]Event, size),
+		nextID: 1,
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+func (b *Broker) Publish(eventType, target, check string, data interface{}) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := Event{
+		ID:     b.nextID,
+		Type:   eventType,
+		Target: target,
+		Check:  check,
+		Time:   time.Now().UTC(),
+		Data:   data,
+	}
+	b.nextID++
+
+	idx := (b.start + b.count) % len(b.buffer)
+	b.buffer[idx] = event
+	if b.count < len(b.buffer) {
+		b.count++
+	} else {
+		b.start = (b.start + 1) % len(b.buffer)
+	}
+
+	for sub := range b.subs {
+		if !sub.filter.Match(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			// Drop subscribers that can't keep up; they can resume with Last-Event-ID.
+			b.closeLocked(sub)
+		}
+	}
+
+	return event
+}
+
+// Subscribe registers a subscriber and returns the buffered events newer than
+// lastID that match the filter. A lastID of zero skips the replay.
+func (b *Broker) Subscribe(filter Filter, lastID uint64) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, 64)
+	sub := &Subscription{C: ch, ch: ch, filter: filter, broker: b}
+	b.subs[sub] = struct{}{}
+
+	var replay []Event
+	if lastID > 0 {
+		for i := 0; i < b.count; i++ {
+			event := b.buffer[(b.start+i)%len(b.buffer)]
+			if event.ID > lastID && filter.Match(event) {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	return sub, replay
+}
+
+// Close ends every open subscription, which lets streaming handlers return
+// during server shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		b.closeLocked(sub)
+	}
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closeLocked(sub)
+}
+
+func (b *Broker) closeLocked(sub *Subscription) {
+	sub.once.Do(func() {
+		delete(b.subs, sub)
+		close(sub.ch)
+	})
+}
+
+// BrokerChecker fails when the broker can't be locked. Publishing would
+// block, and with it the processing of every result.
+type BrokerChecker struct {
+	broker *Broker
+}
+
+func (b *Broker) Checker() *BrokerChecker {
+	return &BrokerChecker{broker: b}
+}
+
+func (c *BrokerChecker) Check(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		c.broker.mu.Lock()
+		c.broker.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		return errors.New("event broker is blocked")
+	}
+}
+
+func (c *BrokerChecker) Name() string {
+	return "event_broker"
+}
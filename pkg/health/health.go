@@ -1,66 +1,157 @@
 package health
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+)
+
+const (
+	StatusHealthy   = "healthy"
+	StatusDegraded  = "degraded"
+	StatusUnhealthy = "unhealthy"
+
+	CheckPass = "pass"
+	CheckFail = "fail"
+
+	defaultTimeout  = 2 * time.Second
+	defaultCacheTTL = 5 * time.Second
 )
 
+// HealthChecker returns nil when healthy and an error describing the
+// problem otherwise. Check should respect ctx; it is abandoned once the
+// per-check timeout expires.
 type HealthChecker interface {
-	Check() bool
+	Check(ctx context.Context) error
 	Name() string
 }
 
-type Health struct {
-	checkers []HealthChecker
-	mu       sync.RWMutex
+// CheckOptions controls which endpoints a checker contributes to and how a
+// failure affects the overall status. Failing non-critical checks degrade
+// the status without failing the endpoint. A negative CacheTTL disables
+// result caching.
+type CheckOptions struct {
+	Liveness  bool
+	Readiness bool
+	Critical  bool
+	Timeout   time.Duration
+	CacheTTL  time.Duration
+}
+
+type CheckResult struct {
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Cached    bool      `json:"cached"`
 }
 
 type HealthStatus struct {
 	Status    string                 `json:"status"`
-	Checks    map[string]bool        `json:"checks"`
+	Checks    map[string]CheckResult `json:"checks"`
 	Timestamp string                 `json:"timestamp"`
 	Info      map[string]interface{} `json:"info,omitempty"`
 }
 
+type entry struct {
+	checker HealthChecker
+	opts    CheckOptions
+
+	// mu serializes runs so concurrent requests share one cached result.
+	mu     sync.Mutex
+	last   CheckResult
+	expiry time.Time
+}
+
+type Health struct {
+	entries []*entry
+	mu      sync.RWMutex
+}
+
 func New() *Health {
 	return &Health{
-		checkers: []HealthChecker{},
+		entries: []*entry{},
+	}
+}
+
+func (h *Health) AddChecker(checker HealthChecker, opts CheckOptions) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = defaultCacheTTL
 	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, &entry{checker: checker, opts: opts})
 }
 
-func (h *Health) AddChecker(checker HealthChecker) {
+// Configure applies per-check overrides from config, keyed by checker name.
+// It must be called before the handlers start serving.
+func (h *Health) Configure(cfg config.HealthConfig) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.checkers = append(h.checkers, checker)
+
+	for _, e := range h.entries {
+		override, exists := cfg.Checks[e.checker.Name()]
+		if !exists {
+			continue
+		}
+		if override.Liveness != nil {
+			e.opts.Liveness = *override.Liveness
+		}
+		if override.Readiness != nil {
+			e.opts.Readiness = *override.Readiness
+		}
+		if override.Critical != nil {
+			e.opts.Critical = *override.Critical
+		}
+		if override.Timeout > 0 {
+			e.opts.Timeout = override.Timeout
+		}
+		if override.CacheTTL != 0 {
+			e.opts.CacheTTL = override.CacheTTL
+		}
+	}
 }
 
+// Handler reports on every registered check.
 func (h *Health) Handler() http.HandlerFunc {
+	return h.handler(func(CheckOptions) bool { return true })
+}
+
+// LivenessHandler reports on checks that decide whether the process should
+// be restarted.
+func (h *Health) LivenessHandler() http.HandlerFunc {
+	return h.handler(func(opts CheckOptions) bool { return opts.Liveness })
+}
+
+// ReadinessHandler reports on checks that decide whether the process should
+// receive traffic.
+func (h *Health) ReadinessHandler() http.HandlerFunc {
+	return h.handler(func(opts CheckOptions) bool { return opts.Readiness })
+}
+
+func (h *Health) handler(include func(CheckOptions) bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		status := HealthStatus{
-			Status:    "healthy",
-			Checks:    make(map[string]bool),
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-		}
-
-		h.mu.RLock()
-		for _, checker := range h.checkers {
-			checkStatus := checker.Check()
-			status.Checks[checker.Name()] = checkStatus
-			if !checkStatus {
-				status.Status = "unhealthy"
-			}
-		}
-		h.mu.RUnlock()
+		status := h.Evaluate(r.Context(), include)
 
 		w.Header().Set("Content-Type", "application/json")
-		if status.Status == "unhealthy" {
+		if status.Status == StatusUnhealthy {
 			w.WriteHeader(http.StatusServiceUnavailable)
 		} else {
 			w.WriteHeader(http.StatusOK)
@@ -68,3 +159,113 @@ func (h *Health) Handler() http.HandlerFunc {
 		json.NewEncoder(w).Encode(status)
 	}
 }
+
+// Evaluate runs the selected checks concurrently and combines their results.
+func (h *Health) Evaluate(ctx context.Context, include func(CheckOptions) bool) HealthStatus {
+	h.mu.RLock()
+	var selected []*entry
+	for _, e := range h.entries {
+		if include(e.opts) {
+			selected = append(selected, e)
+		}
+	}
+	h.mu.RUnlock()
+
+	results := make([]CheckResult, len(selected))
+	var wg sync.WaitGroup
+	for i, e := range selected {
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			results[i] = e.run(ctx)
+		}(i, e)
+	}
+	wg.Wait()
+
+	status := HealthStatus{
+		Status:    StatusHealthy,
+		Checks:    make(map[string]CheckResult, len(selected)),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	for i, e := range selected {
+		result := results[i]
+		status.Checks[e.checker.Name()] = result
+		if result.Status == CheckPass {
+			continue
+		}
+		if result.Critical {
+			status.Status = StatusUnhealthy
+		} else if status.Status == StatusHealthy {
+			status.Status = StatusDegraded
+		}
+	}
+	return status
+}
+
+func (e *entry) run(ctx context.Context) CheckResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if e.opts.CacheTTL > 0 && now.Before(e.expiry) {
+		cached := e.last
+		cached.Cached = true
+		cached.Critical = e.opts.Critical
+		return cached
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.opts.Timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- e.checker.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", e.opts.Timeout)
+	}
+
+	result := CheckResult{
+		Status:    CheckPass,
+		Critical:  e.opts.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: start.UTC(),
+	}
+	if err != nil {
+		result.Status = CheckFail
+		result.Error = err.Error()
+	}
+
+	// Don't cache results cut short by the caller going away.
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		e.last = result
+		e.expiry = start.Add(e.opts.CacheTTL)
+	}
+	return result
+}
+
+// ShutdownChecker reports unhealthy once shutdown has begun, so that load
+// balancers stop routing traffic before the server stops accepting it.
+type ShutdownChecker struct {
+	shuttingDown atomic.Bool
+}
+
+func (c *ShutdownChecker) Check(ctx context.Context) error {
+	if c.shuttingDown.Load() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+func (c *ShutdownChecker) Name() string {
+	return "shutdown"
+}
+
+func (c *ShutdownChecker) SetShuttingDown() {
+	c.shuttingDown.Store(true)
+}
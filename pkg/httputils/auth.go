@@ -0,0 +1,210 @@
+package httputils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+)
+
+const (
+	// Tokens are refreshed this long before they expire, so that a token
+	// doesn't expire while a check is in flight.
+	tokenRefreshMargin = 30 * time.Second
+	// Lifetime assumed for tokens issued without expires_in.
+	defaultTokenLifetime = 5 * time.Minute
+	tokenRequestTimeout  = 10 * time.Second
+	maxTokenRedirects    = 3
+)
+
+// Authenticate adds the credentials of auth, which may be nil, to req.
+// OAuth2 tokens are requested by and cached in tokens.
+func Authenticate(req *http.Request, auth *config.AuthConfig, tokens *TokenCache) error {
+	if auth == nil {
+		return nil
+	}
+	switch {
+	case auth.Basic != nil:
+		password, err := auth.Basic.Password.Value()
+		if err != nil {
+			return fmt.Errorf("basic auth password: %w", err)
+		}
+		req.SetBasicAuth(auth.Basic.Username, password)
+	case auth.Bearer != nil:
+		token, err := auth.Bearer.Value()
+		if err != nil {
+			return fmt.Errorf("bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case auth.OAuth2 != nil:
+		token, err := tokens.Token(req.Context(), *auth.OAuth2)
+		if err != nil {
+			return fmt.Errorf("oauth2 token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// TokenCache holds OAuth2 client credentials tokens per token endpoint,
+// client and scopes until shortly before they expire.
+type TokenCache struct {
+	// client requests tokens. It is separate from the clients of checks,
+	// which may skip certificate verification, since it sends the client
+	// secret.
+	client *http.Client
+	mu     sync.Mutex
+	tokens map[string]*cachedToken
+}
+
+type cachedToken struct {
+	// mu is held while the token is requested, so that concurrent checks
+	// wait for one request instead of each sending their own.
+	mu      sync.Mutex
+	value   string
+	refresh time.Time
+}
+
+func NewTokenCache() *TokenCache {
+	return &TokenCache{
+		client: &http.Client{
+			Transport:     http.DefaultTransport.(*http.Transport).Clone(),
+			Timeout:       tokenRequestTimeout,
+			CheckRedirect: sameHostRedirects,
+		},
+		tokens: make(map[string]*cachedToken),
+	}
+}
+
+// sameHostRedirects follows redirects of the token endpoint only within its
+// host and scheme.
+func sameHostRedirects(req *http.Request, via []*http.Request) error {
+	if len(via) > maxTokenRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxTokenRedirects)
+	}
+	if req.URL.Host != via[0].URL.Host || req.URL.Scheme != via[0].URL.Scheme {
+		return fmt.Errorf("token endpoint redirected to %s://%s", req.URL.Scheme, req.URL.Host)
+	}
+	return nil
+}
+
+// Token returns a cached token for cfg, or requests a new one.
+func (c *TokenCache) Token(ctx context.Context, cfg config.OAuth2Config) (string, error) {
+	entry := c.entry(cfg)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.value != "" && time.Now().Before(entry.refresh) {
+		return entry.value, nil
+	}
+	value, lifetime, err := requestToken(ctx, c.client, cfg)
+	if err != nil {
+		return "", err
+	}
+	refresh := lifetime - tokenRefreshMargin
+	if refresh < lifetime/2 {
+		refresh = lifetime / 2
+	}
+	entry.value, entry.refresh = value, time.Now().Add(refresh)
+	return value, nil
+}
+
+// Invalidate drops the cached token for cfg, e.g. after it was rejected.
+func (c *TokenCache) Invalidate(cfg config.OAuth2Config) {
+	entry := c.entry(cfg)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.value = ""
+}
+
+func (c *TokenCache) entry(cfg config.OAuth2Config) *cachedToken {
+	key := tokenKey(cfg)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, exists := c.tokens[key]
+	if !exists {
+		entry = &cachedToken{}
+		c.tokens[key] = entry
+	}
+	return entry
+}
+
+// tokenKey identifies the tokens a configuration yields. The secret isn't
+// part of it; a rotated secret takes effect with the next token.
+func tokenKey(cfg config.OAuth2Config) string {
+	params := make([]string, 0, len(cfg.EndpointParams))
+	for name, value := range cfg.EndpointParams {
+		params = append(params, name+"="+value)
+	}
+	sort.Strings(params)
+	return strings.Join([]string{cfg.TokenURL, cfg.ClientID, strings.Join(cfg.Scopes, " "), strings.Join(params, "&")}, "\x00")
+}
+
+// requestToken performs the client credentials grant, authenticating the
+// client with HTTP basic auth.
+func requestToken(ctx context.Context, client *http.Client, cfg config.OAuth2Config) (string, time.Duration, error) {
+	secret, err := cfg.ClientSecret.Value()
+	if err != nil {
+		return "", 0, fmt.Errorf("client secret: %w", err)
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(cfg.Scopes, " "))
+	}
+	for name, value := range cfg.EndpointParams {
+		form.Set(name, value)
+	}
+
+	tokenReq, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.Header.Set("Accept", "application/json")
+	tokenReq.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(secret))
+
+	resp, err := client.Do(tokenReq)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string      `json:"access_token"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, err
+	}
+	decodeErr := json.Unmarshal(data, &body)
+	if resp.StatusCode/100 != 2 {
+		if body.Error != "" {
+			return "", 0, fmt.Errorf("token endpoint responded with status code %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+		}
+		return "", 0, fmt.Errorf("token endpoint responded with status code %d", resp.StatusCode)
+	}
+	if decodeErr != nil {
+		return "", 0, fmt.Errorf("invalid token response: %w", decodeErr)
+	}
+	if body.AccessToken == "" {
+		return "", 0, fmt.Errorf("token response has no access_token")
+	}
+
+	lifetime := defaultTokenLifetime
+	if seconds, err := body.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		lifetime = time.Duration(seconds) * time.Second
+	}
+	return body.AccessToken, lifetime, nil
+}
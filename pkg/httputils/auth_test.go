@@ -0,0 +1,197 @@
+package httputils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+)
+
+// tokenServer issues token-1, token-2, ... valid for expiresIn seconds.
+func tokenServer(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var issued atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client"}`)
+			return
+		}
+		if r.FormValue("grant_type") != "client_credentials" {
+			t.Errorf("grant_type = %q", r.FormValue("grant_type"))
+		}
+		n := issued.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %d}`, n, expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+func oauth2Config(t *testing.T, tokenURL string) config.OAuth2Config {
+	t.Setenv("EKOLOD_TEST_CLIENT_SECRET", "s3cret")
+	return config.OAuth2Config{
+		TokenURL:     tokenURL,
+		ClientID:     "client",
+		ClientSecret: config.SecretRef{Env: "EKOLOD_TEST_CLIENT_SECRET"},
+	}
+}
+
+func token(t *testing.T, tokens *TokenCache, cfg config.OAuth2Config) string {
+	t.Helper()
+	value, err := tokens.Token(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	return value
+}
+
+func TestTokenCacheReusesToken(t *testing.T) {
+	server, issued := tokenServer(t, 3600)
+	cfg := oauth2Config(t, server.URL)
+	tokens := NewTokenCache()
+
+	for i := 0; i < 3; i++ {
+		if got := token(t, tokens, cfg); got != "token-1" {
+			t.Fatalf("token = %q, want token-1", got)
+		}
+	}
+	if n := issued.Load(); n != 1 {
+		t.Errorf("requested %d tokens, want 1", n)
+	}
+
+	// Another client of the same endpoint gets its own token
+	other := cfg
+	other.Scopes = []string{"read"}
+	if got := token(t, tokens, other); got != "token-2" {
+		t.Errorf("token for other scopes = %q, want token-2", got)
+	}
+}
+
+func TestTokenCacheRefreshesBeforeExpiry(t *testing.T) {
+	server, issued := tokenServer(t, 60)
+	cfg := oauth2Config(t, server.URL)
+	tokens := NewTokenCache()
+
+	start := time.Now()
+	token(t, tokens, cfg)
+	refresh := tokens.entry(cfg).refresh
+	if want := start.Add(60*time.Second - tokenRefreshMargin); refresh.Before(want) || refresh.After(want.Add(time.Second)) {
+		t.Errorf("refresh at %v after issue, want %v", refresh.Sub(start), 60*time.Second-tokenRefreshMargin)
+	}
+
+	// Short-lived tokens are refreshed halfway through their lifetime
+	server, issued = tokenServer(t, 1)
+	cfg = oauth2Config(t, server.URL)
+	token(t, tokens, cfg)
+	time.Sleep(600 * time.Millisecond)
+	if got := token(t, tokens, cfg); got != "token-2" {
+		t.Errorf("token after half its lifetime = %q, want token-2", got)
+	}
+	if n := issued.Load(); n != 2 {
+		t.Errorf("requested %d tokens, want 2", n)
+	}
+}
+
+func TestTokenCacheInvalidate(t *testing.T) {
+	server, issued := tokenServer(t, 3600)
+	cfg := oauth2Config(t, server.URL)
+	tokens := NewTokenCache()
+
+	token(t, tokens, cfg)
+	tokens.Invalidate(cfg)
+	if got := token(t, tokens, cfg); got != "token-2" {
+		t.Errorf("token after Invalidate = %q, want token-2", got)
+	}
+	if n := issued.Load(); n != 2 {
+		t.Errorf("requested %d tokens, want 2", n)
+	}
+}
+
+func TestTokenCacheConcurrentRequestsShareToken(t *testing.T) {
+	server, issued := tokenServer(t, 3600)
+	cfg := oauth2Config(t, server.URL)
+	tokens := NewTokenCache()
+
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := tokens.Token(context.Background(), cfg)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+	}
+	if n := issued.Load(); n != 1 {
+		t.Errorf("requested %d tokens, want 1", n)
+	}
+}
+
+func TestTokenEndpointError(t *testing.T) {
+	server, _ := tokenServer(t, 3600)
+	cfg := oauth2Config(t, server.URL)
+	cfg.ClientID = "unknown"
+
+	_, err := NewTokenCache().Token(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("Token succeeded with unknown client")
+	}
+	if want := "token endpoint responded with status code 401: invalid_client "; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestAuthenticateOAuth2(t *testing.T) {
+	server, _ := tokenServer(t, 3600)
+	cfg := oauth2Config(t, server.URL)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err := Authenticate(req, &config.AuthConfig{OAuth2: &cfg}, NewTokenCache()); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer token-1" {
+		t.Errorf("Authorization = %q, want Bearer token-1", got)
+	}
+}
+
+func TestTokenRequestsVerifyCertificates(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("client secret sent to an unverified server")
+	}))
+	defer server.Close()
+
+	_, err := NewTokenCache().Token(context.Background(), oauth2Config(t, server.URL))
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("error = %v, want a certificate error", err)
+	}
+}
+
+func TestTokenRequestsStayOnHost(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("token request followed a redirect to another host")
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			http.Redirect(w, r, "/v2/token", http.StatusTemporaryRedirect)
+			return
+		}
+		http.Redirect(w, r, other.URL+"/token", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	_, err := NewTokenCache().Token(context.Background(), oauth2Config(t, server.URL+"/token"))
+	if err == nil || !strings.Contains(err.Error(), "token endpoint redirected to") {
+		t.Errorf("error = %v, want a redirect error", err)
+	}
+}
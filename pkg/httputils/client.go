@@ -3,14 +3,104 @@ package httputils
 import (
 	"crypto/tls"
 	"net/http"
+	"sync"
 	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
 )
 
-func NewHTTPClient() *http.Client {
+// ClientOptions is the resolved form of config.HTTPClientConfig. It is
+// comparable so that clients with identical settings can share a transport.
+type ClientOptions struct {
+	Timeout             time.Duration
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	InsecureSkipVerify  bool
+	DisableKeepAlives   bool
+}
+
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		Timeout:             10 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+		InsecureSkipVerify:  true,
+	}
+}
+
+// ResolveClientOptions applies the global settings and then the optional
+// per-target overrides on top of the defaults.
+func ResolveClientOptions(global config.HTTPClientConfig, override *config.HTTPClientConfig) ClientOptions {
+	opts := DefaultClientOptions()
+	apply := func(c config.HTTPClientConfig) {
+		if c.Timeout > 0 {
+			opts.Timeout = c.Timeout
+		}
+		if c.MaxIdleConns > 0 {
+			opts.MaxIdleConns = c.MaxIdleConns
+		}
+		if c.MaxIdleConnsPerHost > 0 {
+			opts.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+		}
+		if c.IdleConnTimeout > 0 {
+			opts.IdleConnTimeout = c.IdleConnTimeout
+		}
+		if c.InsecureSkipVerify != nil {
+			opts.InsecureSkipVerify = *c.InsecureSkipVerify
+		}
+		if c.DisableKeepAlives {
+			opts.DisableKeepAlives = true
+		}
+	}
+	apply(global)
+	if override != nil {
+		apply(*override)
+	}
+	return opts
+}
+
+func NewHTTPClient(opts ClientOptions) *http.Client {
 	return &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout: opts.Timeout,
 		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify},
+			MaxIdleConns:        opts.MaxIdleConns,
+			MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+			IdleConnTimeout:     opts.IdleConnTimeout,
+			DisableKeepAlives:   opts.DisableKeepAlives,
 		},
 	}
 }
+
+// ClientPool hands out one shared client per distinct set of options so that
+// connections are reused across check runs.
+type ClientPool struct {
+	mu      sync.Mutex
+	clients map[ClientOptions]*http.Client
+}
+
+func NewClientPool() *ClientPool {
+	return &ClientPool{clients: make(map[ClientOptions]*http.Client)}
+}
+
+func (p *ClientPool) Get(opts ClientOptions) *http.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, exists := p.clients[opts]
+	if !exists {
+		client = NewHTTPClient(opts)
+		p.clients[opts] = client
+	}
+	return client
+}
+
+func (p *ClientPool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, client := range p.clients {
+		client.CloseIdleConnections()
+	}
+}
@@ -1,24 +1,158 @@
 package logging
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"os"
+	"strings"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/secrets"
+)
+
+// Field names shared by every log record, so that logs from the probe and
+// the collector can be queried the same way.
+const (
+	KeyTarget     = "target"
+	KeyCheck      = "check"
+	KeyProbeID    = "probe_id"
+	KeyDuration   = "duration"
+	KeyStatusCode = "status_code"
+	KeyError      = "error"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	level  = new(slog.LevelVar)
+	logger = slog.Default()
 )
 
-var logger *log.Logger
+// InitLogger logs records at level and above to stdout, as text or JSON
+// lines. The standard library's log package is redirected to the same
+// logger. Known secrets are redacted from messages and fields.
+func InitLogger(lvl, format string) error {
+	return initLogger(os.Stdout, lvl, format)
+}
+
+func initLogger(w io.Writer, lvl, format string) error {
+	if err := SetLevel(lvl); err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, FormatText, FormatJSON)
+	}
+	logger = slog.New(handler)
+	slog.SetDefault(logger)
+	return nil
+}
+
+// redact removes known secrets from string and error values.
+func redact(_ []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(secrets.Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(secrets.Redact(err.Error()))
+		}
+	}
+	return a
+}
+
+// AddFields attaches fields to every subsequent record.
+func AddFields(args ...any) {
+	logger = logger.With(args...)
+	slog.SetDefault(logger)
+}
+
+// SetLevel changes the minimum level at runtime. An empty level means info.
+func SetLevel(lvl string) error {
+	if lvl == "" {
+		lvl = "info"
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(lvl)); err != nil {
+		return fmt.Errorf("unknown log level %q, expected debug, info, warn or error", lvl)
+	}
+	level.Set(l)
+	return nil
+}
 
-func InitLogger(level string) {
-	logger = log.New(os.Stdout, "EKOL0D: ", log.Ldate|log.Ltime|log.Lshortfile)
+// Level returns the current minimum level in lower case.
+func Level() string {
+	return strings.ToLower(level.Level().String())
 }
 
-func Error(err error) {
-	logger.Println("ERROR: ", err)
+func Debug(msg string, args ...any) {
+	logger.Debug(msg, args...)
 }
 
-func Info(msg string) {
-	logger.Println("INFO: ", msg)
+func Info(msg string, args ...any) {
+	logger.Info(msg, args...)
 }
 
-func Warn(msg string) {
-	logger.Println("WARN: ", msg)
+func Warn(msg string, args ...any) {
+	logger.Warn(msg, args...)
+}
+
+func Error(msg string, args ...any) {
+	logger.Error(msg, args...)
+}
+
+// Fatal logs at error level and exits.
+func Fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Err is the error field of a record.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// LevelHandler reports the current level on GET and changes it on PUT or
+// POST with {"level": "debug"}.
+func LevelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var body struct {
+				Level string `json:"level"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if body.Level == "" {
+				http.Error(w, "level is required", http.StatusBadRequest)
+				return
+			}
+			if err := SetLevel(body.Level); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			Info("Log level changed", "level", Level())
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"level": Level()})
+	}
 }
@@ -9,58 +9,223 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+const namespace = "ekolod"
+
+// Names of the per-check gauges. The collector's remote write uses them too,
+// so that a signal has one name whether it is scraped from a probe or
+// forwarded by the collector.
+const (
+	CheckSuccessName      = "ekolod_check_success"
+	CheckLastDurationName = "ekolod_check_last_duration_seconds"
+	CheckStatusCodeName   = "ekolod_check_status_code"
+	CheckResponseSizeName = "ekolod_check_response_size_bytes"
+	CertExpiryDaysName    = "ekolod_check_cert_expiry_days"
+)
+
+// States reported by ekolod_target_state, mirroring the probe's states.
+var targetStates = []string{"unknown", "up", "down"}
+
 var (
-	HttpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name: "http_request_duration_seconds",
-		Help: "Duration of HTTP requests.",
-	}, []string{"target", "path", "method"})
+	CheckSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: CheckSuccessName,
+		Help: "Whether the last run of the check succeeded.",
+	}, []string{"target", "check"})
+
+	CheckDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "check_duration_seconds",
+		Help:      "Duration of check requests.",
+	}, []string{"target", "check"})
+
+	CheckLastDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: CheckLastDurationName,
+		Help: "Duration of the last run of the check.",
+	}, []string{"target", "check"})
+
+	CheckStatusCode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: CheckStatusCodeName,
+		Help: "HTTP status code of the last run, 0 if there was no response.",
+	}, []string{"target", "check"})
+
+	CheckResponseSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: CheckResponseSizeName,
+		Help: "Content length of the last response, -1 if unknown.",
+	}, []string{"target", "check"})
 
-	HttpResponseSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "http_response_size_bytes",
-		Help: "Size of HTTP responses in bytes.",
-	}, []string{"target", "path"})
+	CheckConsecutiveFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "check_consecutive_failures",
+		Help:      "Number of failed runs since the check last succeeded.",
+	}, []string{"target", "check"})
+
+	CheckRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "check_runs_total",
+		Help:      "Check runs by outcome (success or failure).",
+	}, []string{"target", "check", "outcome"})
 
 	TLSVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "tls_version",
-		Help: "TLS version used for the connection.",
-	}, []string{"target", "version"})
+		Namespace: namespace,
+		Name:      "check_tls_version_info",
+		Help:      "TLS version used for the connection.",
+	}, []string{"target", "check", "version"})
 
 	CertExpiryDays = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "cert_expiry_days",
-		Help: "Number of days until the SSL certificate expires.",
-	}, []string{"target"})
+		Name: CertExpiryDaysName,
+		Help: "Number of days until the server certificate expires.",
+	}, []string{"target", "check"})
+
+	TargetState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "target_state",
+		Help:      "Current state of the target after failure_tolerance and recovery_threshold; 1 for the current state, 0 otherwise.",
+	}, []string{"target", "state"})
+
+	SchedulerLag = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scheduler_lag_seconds",
+		Help:      "Delay between when a check was due and when a worker started running it.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30},
+	})
+
+	SchedulerSkippedRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduler_skipped_runs_total",
+		Help:      "Check runs skipped because the previous run was still in flight.",
+	}, []string{"target", "check"})
+
+	SchedulerWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scheduler_workers",
+		Help:      "Number of checks that may run at the same time.",
+	})
+
+	SchedulerInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scheduler_in_flight_checks",
+		Help:      "Number of checks currently running.",
+	})
+
+	PushQueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "push_queue_length",
+		Help:      "Results waiting to be pushed to the collector.",
+	})
+
+	PushOutboxLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "push_outbox_length",
+		Help:      "Results waiting to be retried after a failed push.",
+	})
+
+	PushResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "push_results_total",
+		Help:      "Attempts to push a result to the collector by outcome (sent or failed).",
+	}, []string{"outcome"})
+
+	PushDroppedResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "push_dropped_results_total",
+		Help:      "Results given up on without reaching the collector, by reason (queue_full, outbox_full, expired or stopped).",
+	}, []string{"reason"})
+
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Configuration reloads by outcome (success or failure).",
+	}, []string{"outcome"})
 )
 
-func InitMetrics() {
-	prometheus.MustRegister(HttpRequestDuration)
-	prometheus.MustRegister(HttpResponseSize)
-	prometheus.MustRegister(TLSVersion)
-	prometheus.MustRegister(CertExpiryDays)
+// perTarget lists the vectors with a target label, whose series are deleted
+// along with the target or check.
+var perTarget = []interface {
+	DeletePartialMatch(prometheus.Labels) int
+}{
+	CheckSuccess,
+	CheckDuration,
+	CheckLastDuration,
+	CheckStatusCode,
+	CheckResponseSize,
+	CheckConsecutiveFailures,
+	CheckRuns,
+	TLSVersion,
+	CertExpiryDays,
+	TargetState,
+	SchedulerSkippedRuns,
+}
+
+// InitMetrics registers the metrics with the default registry. Every series
+// is labeled with probe, so that several probes can be told apart.
+func InitMetrics(probe string) {
+	registerer := prometheus.WrapRegistererWith(prometheus.Labels{"probe": probe}, prometheus.DefaultRegisterer)
+	registerer.MustRegister(
+		CheckSuccess,
+		CheckDuration,
+		CheckLastDuration,
+		CheckStatusCode,
+		CheckResponseSize,
+		CheckConsecutiveFailures,
+		CheckRuns,
+		TLSVersion,
+		CertExpiryDays,
+		TargetState,
+		SchedulerLag,
+		SchedulerSkippedRuns,
+		SchedulerWorkers,
+		SchedulerInFlight,
+		PushQueueLength,
+		PushOutboxLength,
+		PushResults,
+		PushDroppedResults,
+		ConfigReloads,
+	)
 }
 
 func UpdatePrometheusMetrics(target *config.Target, check config.Check, result *proberesult.ProbeResult) {
-	HttpRequestDuration.With(prometheus.Labels{
-		"target": target.Name,
-		"path":   check.Path,
-		"method": "GET",
-	}).Observe(result.Duration)
+	labels := prometheus.Labels{"target": target.Name, "check": check.ID()}
 
-	HttpResponseSize.With(prometheus.Labels{
-		"target": target.Name,
-		"path":   check.Path,
-	}).Set(float64(result.ContentLength))
+	success, outcome := 0.0, "failure"
+	if result.Success {
+		success, outcome = 1, "success"
+	}
+	CheckSuccess.With(labels).Set(success)
+	CheckRuns.WithLabelValues(target.Name, check.ID(), outcome).Inc()
+	CheckDuration.With(labels).Observe(result.Duration)
+	CheckLastDuration.With(labels).Set(result.Duration)
+	CheckStatusCode.With(labels).Set(float64(result.StatusCode))
+	CheckResponseSize.With(labels).Set(float64(result.ContentLength))
 
 	if result.TLSVersion != "" {
-		TLSVersion.With(prometheus.Labels{
-			"target":  target.Name,
-			"version": result.TLSVersion,
-		}).Set(1)
+		// Drop the series of a previously negotiated version
+		TLSVersion.DeletePartialMatch(labels)
+		TLSVersion.WithLabelValues(target.Name, check.ID(), result.TLSVersion).Set(1)
+		CertExpiryDays.With(labels).Set(float64(result.CertExpiryDays))
 	}
+}
+
+// SetTargetState marks state as the target's current state.
+func SetTargetState(target, state string) {
+	for _, s := range targetStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		TargetState.WithLabelValues(target, s).Set(value)
+	}
+}
+
+// DeleteTarget removes every series of a target.
+func DeleteTarget(target string) {
+	for _, vec := range perTarget {
+		vec.DeletePartialMatch(prometheus.Labels{"target": target})
+	}
+}
 
-	if result.CertExpiryDays != 0 {
-		CertExpiryDays.With(prometheus.Labels{
-			"target": target.Name,
-		}).Set(float64(result.CertExpiryDays))
+// DeleteCheck removes the series of one of a target's checks.
+func DeleteCheck(target, check string) {
+	for _, vec := range perTarget {
+		vec.DeletePartialMatch(prometheus.Labels{"target": target, "check": check})
 	}
 }
 
@@ -2,16 +2,48 @@ package metricspusher
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/c-j-p-nordquist/ekolod/pkg/checker"
 	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+	"github.com/c-j-p-nordquist/ekolod/pkg/metrics"
+	"github.com/c-j-p-nordquist/ekolod/pkg/proberesult"
 )
 
-var collectorURL string
+const (
+	queueSize = 1024
+	// Results that fail to push wait in the outbox and are retried in order
+	// with backoff between these bounds. The outbox drops its oldest result
+	// when full, and results older than maxRetryAge.
+	outboxSize      = 4096
+	maxRetryAge     = 15 * time.Minute
+	minRetryBackoff = 100 * time.Millisecond
+	maxRetryBackoff = 5 * time.Second
+)
+
+var (
+	collectorURL string
+	probeID      string
+	location     string
+	client       = &http.Client{Timeout: 10 * time.Second}
+
+	queue     chan pending
+	queueMu   sync.RWMutex
+	closed    bool
+	flushCtx  context.Context
+	senderWG  sync.WaitGroup
+	startOnce sync.Once
+	// unsent counts the results in the queue and the outbox.
+	unsent atomic.Int64
+)
 
 type ProbeResult struct {
 	Duration       float64 `json:"duration"`
@@ -21,6 +53,21 @@ type ProbeResult struct {
 	ContentLength  int64   `json:"contentLength"`
 	TLSVersion     string  `json:"tlsVersion"`
 	CertExpiryDays int     `json:"certExpiryDays"`
+	// Assertions is the outcome of each of the check's conditions.
+	Assertions []checker.AssertionResult `json:"assertions,omitempty"`
+	// Steps is the outcome of each request of a multi-step check.
+	Steps []proberesult.StepResult `json:"steps,omitempty"`
+	// Redirects are the redirects that were followed, in order.
+	Redirects []checker.Redirect `json:"redirects,omitempty"`
+}
+
+type pending struct {
+	target *config.Target
+	check  config.Check
+	result *ProbeResult
+	// time is when the result was enqueued, which the collector records
+	// instead of the time it receives a retried result.
+	time time.Time
 }
 
 func Init(apiURL string) error {
@@ -29,33 +76,226 @@ func Init(apiURL string) error {
 		return fmt.Errorf("invalid collector URL: %v", err)
 	}
 	collectorURL = parsedURL.String()
+
+	startOnce.Do(func() {
+		queue = make(chan pending, queueSize)
+		senderWG.Add(1)
+		go send()
+	})
 	return nil
 }
 
+// SetOrigin identifies this probe and where it runs in every pushed result,
+// so the collector can tell which locations saw a failure.
+func SetOrigin(id, loc string) {
+	probeID = id
+	location = loc
+}
+
 func GetCollectorURL() string {
 	return collectorURL
 }
 
+// Enqueue hands a result to the background sender without blocking the
+// caller. Results are dropped if the queue is full or the pusher is flushing.
+func Enqueue(target *config.Target, check config.Check, result *ProbeResult) {
+	queueMu.RLock()
+	defer queueMu.RUnlock()
+
+	if queue == nil || closed {
+		metrics.PushDroppedResults.WithLabelValues("stopped").Inc()
+		logging.Warn("Dropping result, pusher is not running", logging.KeyTarget, target.Name, logging.KeyCheck, check.ID())
+		return
+	}
+
+	select {
+	case queue <- pending{target: target, check: check, result: result, time: time.Now()}:
+		unsent.Add(1)
+		metrics.PushQueueLength.Inc()
+	default:
+		metrics.PushDroppedResults.WithLabelValues("queue_full").Inc()
+		logging.Warn("Dropping result, push queue is full", logging.KeyTarget, target.Name, logging.KeyCheck, check.ID())
+	}
+}
+
+// Flush stops accepting new results and waits until every queued result and
+// the outbox have been pushed or ctx is done. Failed pushes are retried
+// until then, and the results left are dropped.
+func Flush(ctx context.Context) error {
+	queueMu.Lock()
+	if queue == nil || closed {
+		queueMu.Unlock()
+		return nil
+	}
+	closed = true
+	flushCtx = ctx
+	close(queue)
+	queueMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		senderWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("flushing pending pushes: %d results not sent: %w", unsent.Load(), ctx.Err())
+	}
+}
+
+// send pushes queued results in order. Once a push fails, the result and
+// those queued after it go to the outbox, which is retried with backoff, so
+// results keep their order and an unreachable collector isn't sent a
+// request for every result.
+func send() {
+	defer senderWG.Done()
+
+	var (
+		outbox  []pending
+		backoff = minRetryBackoff
+		retryAt time.Time
+		in      = queue
+	)
+	defer func() {
+		for range outbox {
+			drop("stopped")
+		}
+		metrics.PushOutboxLength.Set(0)
+	}()
+
+	for in != nil || len(outbox) > 0 {
+		var retry <-chan time.Time
+		if len(outbox) > 0 {
+			retry = time.After(time.Until(retryAt))
+		}
+		ctx, done := context.Background(), (<-chan struct{})(nil)
+		if flush := flushing(); flush != nil {
+			ctx, done = flush, flush.Done()
+		}
+
+		select {
+		case p, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			metrics.PushQueueLength.Dec()
+			if len(outbox) == 0 {
+				if err := pushPending(ctx, p); err == nil {
+					continue
+				}
+				retryAt = time.Now().Add(backoff)
+			}
+			outbox = appendOutbox(outbox, p)
+
+		case <-retry:
+			outbox = expireOutbox(outbox, time.Now())
+			for len(outbox) > 0 {
+				if err := pushPending(ctx, outbox[0]); err != nil {
+					backoff = min(2*backoff, maxRetryBackoff)
+					retryAt = time.Now().Add(backoff)
+					break
+				}
+				outbox = outbox[1:]
+				backoff = minRetryBackoff
+			}
+
+		case <-done:
+			// The flush deadline passed; drop what is left. Flushing
+			// closed the queue, so this doesn't block.
+			if in != nil {
+				for range in {
+					metrics.PushQueueLength.Dec()
+					drop("stopped")
+				}
+			}
+			return
+		}
+		metrics.PushOutboxLength.Set(float64(len(outbox)))
+	}
+}
+
+// pushPending pushes p once and counts the outcome. A result that was sent
+// is no longer unsent.
+func pushPending(ctx context.Context, p pending) error {
+	if err := push(ctx, p.target, p.check, p.result, p.time); err != nil {
+		metrics.PushResults.WithLabelValues("failed").Inc()
+		logging.Warn("Failed to push result, will retry", logging.KeyTarget, p.target.Name, logging.KeyCheck, p.check.ID(), logging.Err(err))
+		return err
+	}
+	metrics.PushResults.WithLabelValues("sent").Inc()
+	unsent.Add(-1)
+	return nil
+}
+
+// appendOutbox adds p to the outbox, dropping the oldest result if the
+// outbox is full.
+func appendOutbox(outbox []pending, p pending) []pending {
+	if len(outbox) >= outboxSize {
+		logging.Warn("Dropping result, push outbox is full", logging.KeyTarget, outbox[0].target.Name, logging.KeyCheck, outbox[0].check.ID())
+		drop("outbox_full")
+		outbox = outbox[1:]
+	}
+	return append(outbox, p)
+}
+
+// expireOutbox drops the results that are too old to be worth retrying.
+func expireOutbox(outbox []pending, now time.Time) []pending {
+	for len(outbox) > 0 && now.Sub(outbox[0].time) > maxRetryAge {
+		logging.Warn("Dropping result, retried for too long", logging.KeyTarget, outbox[0].target.Name, logging.KeyCheck, outbox[0].check.ID())
+		drop("expired")
+		outbox = outbox[1:]
+	}
+	return outbox
+}
+
+func drop(reason string) {
+	metrics.PushDroppedResults.WithLabelValues(reason).Inc()
+	unsent.Add(-1)
+}
+
+// flushing returns the context of the flush in progress, or nil.
+func flushing() context.Context {
+	queueMu.RLock()
+	defer queueMu.RUnlock()
+	return flushCtx
+}
+
 func PushMetricsToCollector(target *config.Target, check config.Check, result *ProbeResult) error {
+	return push(context.Background(), target, check, result, time.Now())
+}
+
+func push(ctx context.Context, target *config.Target, check config.Check, result *ProbeResult, at time.Time) error {
 	if collectorURL == "" {
 		return fmt.Errorf("collector URL is not set")
 	}
 
 	payload := map[string]interface{}{
 		"target": target.Name,
-		"check":  check.Path,
+		"check":  check.ID(),
 		"result": result,
+		"time":   at.UTC(),
+	}
+	if probeID != "" {
+		payload["probe"] = probeID
+	}
+	if location != "" {
+		payload["location"] = location
 	}
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %v", err)
 	}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, collectorURL+"/metrics", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %v", err)
 	}
-
-	resp, err := client.Post(collectorURL+"/metrics", "application/json", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to push metrics: %v", err)
 	}
@@ -0,0 +1,77 @@
+package metricspusher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c-j-p-nordquist/ekolod/pkg/config"
+	"github.com/c-j-p-nordquist/ekolod/pkg/logging"
+)
+
+func TestFailedPushesAreRetriedInOrder(t *testing.T) {
+	logging.InitLogger("error", "text")
+
+	var (
+		mu       sync.Mutex
+		failures = 3
+		received []string
+		times    []time.Time
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload struct {
+			Check string    `json:"check"`
+			Time  time.Time `json:"time"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decoding payload: %v", err)
+		}
+		received = append(received, payload.Check)
+		times = append(times, payload.Time)
+	}))
+	defer server.Close()
+
+	if err := Init(server.URL); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	target := &config.Target{Name: "api"}
+	start := time.Now()
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		Enqueue(target, config.Check{Path: path}, &ProbeResult{Success: true})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	want := []string{"/a", "/b", "/c", "/d"}
+	if len(received) != len(want) {
+		t.Fatalf("received %v, want %v", received, want)
+	}
+	for i := range want {
+		if received[i] != want[i] {
+			t.Errorf("received %v, want %v", received, want)
+			break
+		}
+		// Results are reported at the time they were enqueued, not sent
+		if times[i].Before(start.Add(-time.Second)) || times[i].After(start.Add(100*time.Millisecond)) {
+			t.Errorf("result %s reported at %v, enqueued at %v", want[i], times[i], start)
+		}
+	}
+	if n := unsent.Load(); n != 0 {
+		t.Errorf("%d results unsent after flushing", n)
+	}
+}
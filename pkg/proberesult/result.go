@@ -1,5 +1,10 @@
 package proberesult
 
+import (
+	"github.com/c-j-p-nordquist/ekolod/pkg/checker"
+	"github.com/c-j-p-nordquist/ekolod/pkg/secrets"
+)
+
 type ProbeResult struct {
 	Duration       float64
 	Success        bool
@@ -8,6 +13,26 @@ type ProbeResult struct {
 	ContentLength  int64
 	TLSVersion     string
 	CertExpiryDays int
+	Assertions     []checker.AssertionResult
+	// Steps holds the outcome of each request of a multi-step check.
+	Steps []StepResult
+	// Redirects are the redirects that were followed, in order.
+	Redirects []checker.Redirect
+}
+
+// StepResult is the outcome of one request of a multi-step check. Captured
+// lists the names of the variables it set, not their values.
+type StepResult struct {
+	Name       string                    `json:"name"`
+	Method     string                    `json:"method"`
+	URL        string                    `json:"url"`
+	StatusCode int                       `json:"statusCode"`
+	Duration   float64                   `json:"duration"`
+	Success    bool                      `json:"success"`
+	Message    string                    `json:"message"`
+	Assertions []checker.AssertionResult `json:"assertions,omitempty"`
+	Captured   []string                  `json:"captured,omitempty"`
+	Redirects  []checker.Redirect        `json:"redirects,omitempty"`
 }
 
 func New(duration float64) *ProbeResult {
@@ -21,8 +46,9 @@ func (r *ProbeResult) SetSuccess(success bool) {
 	r.Success = success
 }
 
+// SetMessage sets the message with any known secret redacted.
 func (r *ProbeResult) SetMessage(message string) {
-	r.Message = message
+	r.Message = secrets.Redact(message)
 }
 
 func (r *ProbeResult) SetStatusCode(statusCode int) {
@@ -40,3 +66,29 @@ func (r *ProbeResult) SetTLSVersion(tlsVersion string) {
 func (r *ProbeResult) SetCertExpiryDays(days int) {
 	r.CertExpiryDays = days
 }
+
+// SetAssertions records the assertion results with known secrets redacted
+// from their actual values and messages, which may quote the response.
+func (r *ProbeResult) SetAssertions(assertions []checker.AssertionResult) {
+	r.Assertions = nil
+	for _, assertion := range assertions {
+		assertion.Actual = secrets.Redact(assertion.Actual)
+		assertion.Message = secrets.Redact(assertion.Message)
+		r.Assertions = append(r.Assertions, assertion)
+	}
+}
+
+// SetRedirects records the redirects with passwords and known secrets in
+// their URLs redacted.
+func (r *ProbeResult) SetRedirects(redirects []checker.Redirect) {
+	r.Redirects = nil
+	for _, redirect := range redirects {
+		redirect.URL = secrets.RedactURL(redirect.URL)
+		redirect.Location = secrets.RedactURL(redirect.Location)
+		r.Redirects = append(r.Redirects, redirect)
+	}
+}
+
+func (r *ProbeResult) AddStep(step StepResult) {
+	r.Steps = append(r.Steps, step)
+}
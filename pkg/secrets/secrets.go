@@ -0,0 +1,100 @@
+// Package secrets keeps track of secret values, such as passwords and
+// tokens read from the configuration, the environment or files, so that
+// they can be removed from log records, error messages and API responses.
+package secrets
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secret values.
+const Redacted = "<redacted>"
+
+// Values shorter than this aren't tracked. Replacing them would garble
+// messages while hiding next to nothing.
+const minLength = 4
+
+var (
+	mu       sync.RWMutex
+	values   = make(map[string]struct{})
+	replacer = strings.NewReplacer()
+)
+
+// Register marks value as secret.
+func Register(value string) {
+	if len(value) < minLength {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := values[value]; exists {
+		return
+	}
+	values[value] = struct{}{}
+
+	// Longer values go first, so that a secret containing another one is
+	// replaced as a whole.
+	sorted := make([]string, 0, len(values))
+	for v := range values {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, v := range sorted {
+		pairs = append(pairs, v, Redacted)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// RegisterURL marks the password of a URL, if it has one, as secret.
+func RegisterURL(raw string) {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return
+	}
+	if password, ok := u.User.Password(); ok {
+		Register(password)
+	}
+}
+
+// Redact replaces every registered secret in s.
+func Redact(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return replacer.Replace(s)
+}
+
+// RedactWith replaces every registered secret and every one of values in s.
+// The values aren't registered. This suits secrets that are only valid for
+// a while, such as tokens a check obtains, which would otherwise pile up.
+func RedactWith(s string, values ...string) string {
+	sorted := make([]string, 0, len(values))
+	for _, v := range values {
+		if len(v) >= minLength {
+			sorted = append(sorted, v)
+		}
+	}
+	if len(sorted) > 0 {
+		sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+		pairs := make([]string, 0, 2*len(sorted))
+		for _, v := range sorted {
+			pairs = append(pairs, v, Redacted)
+		}
+		s = strings.NewReplacer(pairs...).Replace(s)
+	}
+	return Redact(s)
+}
+
+// RedactURL hides the password of a URL. Unparsable URLs are redacted as a
+// whole.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Redacted
+	}
+	return Redact(u.Redacted())
+}